@@ -0,0 +1,46 @@
+package llm
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bincooo/zerobot-llm/ring"
+)
+
+// 每个聊天室最近的消息，条数和时间窗口可通过 /config.messages 修改
+const (
+	messageL       = 10
+	messageMinutes = 10
+)
+
+var (
+	chats  = make(map[int64]*ring.Buffer[cacheMessage])
+	chatMu sync.Mutex
+)
+
+func loadChat(uid int64) *ring.Buffer[cacheMessage] {
+	chatMu.Lock()
+	defer chatMu.Unlock()
+	if b, ok := chats[uid]; ok {
+		return b
+	}
+
+	size, minutes := chatOption()
+	b := ring.New[cacheMessage](size, time.Duration(minutes)*time.Minute)
+	chats[uid] = b
+	return b
+}
+
+func chatOption() (size int, minutes int) {
+	return Db.OptionInt("messages", messageL), Db.OptionInt("messages_minutes", messageMinutes)
+}
+
+// 修改已有聊天室的缓冲大小
+func resizeChats() {
+	size, minutes := chatOption()
+	chatMu.Lock()
+	defer chatMu.Unlock()
+	for _, b := range chats {
+		b.Resize(size, time.Duration(minutes)*time.Minute)
+	}
+}
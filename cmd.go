@@ -3,13 +3,21 @@ package llm
 import (
 	"fmt"
 	"math/rand"
+	"net/url"
+	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/FloatTech/zbputils/control"
+	"github.com/bincooo/zerobot-llm/emojis"
+	"github.com/bincooo/zerobot-llm/export"
+	"github.com/bincooo/zerobot-llm/model"
+	"github.com/bincooo/zerobot-llm/render"
+	"github.com/bincooo/zerobot-llm/schedule"
 	"github.com/sirupsen/logrus"
 	"github.com/wdvxdr1123/ZeroBot/extension/rate"
 	"github.com/wdvxdr1123/ZeroBot/message"
@@ -29,19 +37,45 @@ var (
 			"/config.proxies 默认代理\n" +
 			"/config.imitate 默认开启自由发言 (true|false)\n" +
 			"/config.freq    自由发言频率 (0~100)\n" +
-			"/keys          查看所有key\n" +
+			"/config.weight  自由发言信号权重 mention|question|burst|idle|reply (-100~100) [群号]\n" +
+			"/config.keywords 自由发言关键词，逗号分隔，- 清空 [群号]\n" +
+			"/config.schedule 自由发言时段 23:00-07:00=0,12:00-13:30=50 (缩放%，- 清空) [群号]\n" +
+			"/config.cooldown 连续自由发言N次后冷却M分钟，沉默5分钟重新计数 (N为0关闭) [群号]\n" +
+			"/config.messages 自由发言参考最近N条、M分钟内的消息\n" +
+			"/config.format Key text|structured 群聊消息格式，structured 每人一条消息\n" +
+			"/config.emoji Key keep|strip|collapse|cap N|whitelist 😀👍 回复表情策略\n" +
+			"/config.typing 每秒字数 浮动% 合并字数 模拟打字节奏 (每秒字数为0关闭)\n" +
+			"/config.delivery final|progressive [每段字数] 普通模式一次发送｜按段落分段发送\n" +
+			"/config.forward 超过该字数的回复以合并转发发送 (0关闭)\n" +
+			"/config.render auto|always|off 回复渲染成图片，auto 含代码块｜表格时渲染\n" +
+			"/config.latex  回复中的公式渲染成图片 (true|false)\n" +
+			"/config.queue   请求排队，关闭时限流直接丢弃 (true|false)\n" +
+			"/config.queueSize 每个聊天室排队上限\n" +
+			"/config.concurrency 上游并发上限 [Key] (0不限制)\n" +
+			"/config.retentionDays 对话记录保留天数 (0永久)\n" +
+			"/config.retentionRows 每个聊天室每个key保留条数 (0不限)\n" +
+			"/config.vacuum  VACUUM 间隔小时 (0关闭)\n" +
+			"/status        查看并发与排队情况\n" +
+			"/db            查看各群数据库占用\n" +
+			"/keys          查看所有key (*为默认)\n" +
+			"/models [Key]  查看key可用的模型\n" +
 			"/set-key       添加｜修改key (私聊)\n" +
 			"/del-key       删除key\n" +
+			"/stop | 停     中断当前回复\n" +
+			"/regen [Key]   重新生成上一条回复\n" +
+			"/continue [Key] 继续被截断的回复\n" +
+			"/history [Key] 查看最近的对话及分支\n" +
+			"/edit id ??    修改某轮提问并从该处开启新分支\n" +
+			"/fork id       切换到该轮对话所在分支\n" +
+			"/export [Key] [json|md|jsonl] 导出对话记录\n" +
+			"/import [Key]  导入最近上传的对话记录文件\n" +
 			"/chat [Key] ?? 指定key进行聊天\n" +
 			"@Bot ??        艾特机器人使用默认key聊天",
 		PrivateDataFolder: "llm",
 	})
 
-	chatMessages map[int64][]cacheMessage
-	fmtMessage   = "%s uid为 [ %d ], 昵称为[ %s ] 的群友发送消息: \n%s"
-	messageL     = 10
-	historyL     = 50
-	mu           sync.Mutex
+	fmtMessage = "%s uid为 [ %d ], 昵称为[ %s ] 的群友发送消息: \n%s"
+	historyL   = 50
 
 	// 每个聊天室限流3s一次
 	limitManager = rate.NewManager[int64](3*time.Second, 1)
@@ -59,17 +93,24 @@ func (c cacheMessage) String() string {
 }
 
 func init() {
-	chatMessages = make(map[int64][]cacheMessage)
+	engine.OnFullMatchGroup([]string{"/stop", "停"}, generating).SetBlock(true).Handle(func(ctx *zero.Ctx) {
+		uid := ctx.Event.UserID
+		if ctx.Event.GroupID > 0 {
+			uid = ctx.Event.GroupID
+		}
+		stopGeneration(uid)
+	})
+
 	engine.OnMessage(onDb).Handle(func(ctx *zero.Ctx) {
 		if zero.OnlyToMe(ctx) {
 			return
 		}
 
-		c := Db.config()
+		c := Db.Config()
 		if !c.Imitate {
 			return
 		}
-		k, err := Db.key(c.Key)
+		k, err := Db.Key(c.Key)
 		if err != nil {
 			return
 		}
@@ -85,59 +126,34 @@ func init() {
 				uid = ctx.Event.GroupID
 			}
 
-			mu.Lock()
-			chatMessages[uid] = append(chatMessages[uid], cacheMessage{
+			chat := loadChat(uid)
+			chat.Push(time.Now(), cacheMessage{
 				Time:     time.Now(),
 				uid:      ctx.Event.UserID,
 				nickname: name,
 				content:  plainMessage,
 			})
 
-			// 控制条数
-			if l := len(chatMessages); l > messageL {
-				chatMessages[uid] = chatMessages[uid][l-messageL:]
-			}
-
 			// 限流
 			if time.Now().Before(limit) {
 				logrus.Warnf("当前请求限流: %d", uid)
+				rateLimited.Inc("cooldown")
 				// ctx.SendChain(message.Reply(ctx.Event.MessageID), message.Text("已限流，请稍后再试..."))
 				return
 			}
 			limiter := limitManager.Load(uid)
 			if !limiter.Acquire() {
-				mu.Unlock()
 				logrus.Warnf("当前请求限流: %d", uid)
+				rateLimited.Inc("limiter")
 				return
 			}
 
-			// 随机回复
+			// 按触发评分随机回复
+			recent := chat.Count(time.Now().Add(-burstWindow))
 			r := rand.New(rand.NewSource(time.Now().UnixNano()))
-			if r.Intn(100) < c.Freq {
-				histories, e := Db.findHistory(uid, k.Name, historyL)
-				if e != nil && !IsSqlNull(e) {
-					logrus.Error(e)
-					mu.Unlock()
-					return
-				}
-
-				messages := chatMessages[uid]
-				chatMessages[uid] = nil
-				mu.Unlock()
-
-				now := time.Now()
-				strMessages := make([]string, 0)
-				for _, msg := range messages {
-					if msg.Time.After(now.Add(-10 * time.Minute)) {
-						strMessages = append(strMessages, msg.String())
-					}
-				}
-
-				if len(strMessages) > 0 {
-					completions(ctx, uid, k.Name, strings.Join(strMessages, "\n\n"), histories)
-				}
-			} else {
-				mu.Unlock()
+			if r.Intn(100) < triggerScore(ctx, c, uid, plainMessage, recent) {
+				imitateTriggers.Inc()
+				imitate(ctx, uid, k.Name, chat)
 			}
 		}
 	})
@@ -153,7 +169,7 @@ func init() {
 			return
 		}
 
-		c := Db.config()
+		c := Db.Config()
 		plainMessage := ExtPlainMessage(ctx)
 		if len(plainMessage) == 0 {
 			emojis := []string{"😀", "😂", "🙃", "🥲", "🤔", "🤨"}
@@ -161,11 +177,13 @@ func init() {
 			return
 		}
 
+		if tex := strings.TrimSpace(plainMessage); (tex == "/stop" || tex == "停") && stopGeneration(uid) {
+			return
+		}
+
 		if plainMessage == "reset" || plainMessage == "消除记忆" {
-			mu.Lock()
-			defer mu.Unlock()
-			chatMessages[uid] = nil
-			err := Db.cleanHistories(uid, c.Key)
+			loadChat(uid).Clear()
+			err := Db.CleanHistories(uid, c.Key)
 			if err != nil {
 				ctx.Send(message.Text("ERROR: ", err))
 				return
@@ -174,49 +192,21 @@ func init() {
 			return
 		}
 
-		// 限流
-		if time.Now().Before(limit) {
-			logrus.Warnf("当前请求限流: %d", uid)
-			//ctx.SendChain(message.Reply(ctx.Event.MessageID), message.Text("已限流，请稍后再试..."))
-			return
-		}
-		limiter := limitManager.Load(uid)
-		if !limiter.Acquire() {
-			logrus.Warnf("当前请求限流: %d", uid)
-			return
-		}
-
-		histories, err := Db.findHistory(uid, c.Key, historyL)
-		if err != nil && !IsSqlNull(err) {
-			ctx.Send(message.Text("ERROR: ", err))
-			return
-		}
-
 		botN := ""
 		if len(zero.BotConfig.NickName) > 0 {
 			botN = fmt.Sprintf("@%s ", zero.BotConfig.NickName[0])
 		}
 
-		mu.Lock()
+		chat := loadChat(uid)
 		if c.Imitate {
-			strMessages := make([]string, 0)
 			now := time.Now()
-			for _, msg := range chatMessages[uid] {
-				if msg.After(now.Add(-10 * time.Minute)) {
-					strMessages = append(strMessages, msg.String())
-				}
-			}
-
-			strMessages = append(strMessages, cacheMessage{now, ctx.Event.UserID, ctx.CardOrNickName(ctx.Event.UserID), botN + plainMessage}.String())
-			plainMessage = strings.Join(strMessages, "\n\n")
-		} else {
-			plainMessage = botN + plainMessage
+			messages := append(chat.Drain(now), cacheMessage{now, ctx.Event.UserID, ctx.CardOrNickName(ctx.Event.UserID), botN + plainMessage})
+			submit(ctx, uid, c.Key, joinMessages(c.Key, messages), historyL)
+			return
 		}
 
-		chatMessages[uid] = nil
-		mu.Unlock()
-
-		completions(ctx, uid, c.Key, plainMessage, histories)
+		chat.Clear()
+		submit(ctx, uid, c.Key, textPrompt(botN+plainMessage), historyL)
 	})
 
 	engine.OnRegex(`^/chat\s+(\S+)\s*(.*)$`, onDb).SetBlock(true).Handle(func(ctx *zero.Ctx) {
@@ -239,7 +229,7 @@ func init() {
 		}
 
 		if msg == "reset" || msg == "消除记忆" {
-			err := Db.cleanHistories(uid, matched[1])
+			err := Db.CleanHistories(uid, matched[1])
 			if err != nil {
 				ctx.Send(message.Text("ERROR: ", err))
 				return
@@ -248,31 +238,228 @@ func init() {
 			return
 		}
 
-		// 限流
-		if time.Now().Before(limit) {
-			logrus.Warnf("当前请求限流: %d", uid)
-			//ctx.SendChain(message.Reply(ctx.Event.MessageID), message.Text("已限流，请稍后再试..."))
+		submit(ctx, uid, matched[1], textPrompt(msg), 100)
+	})
+
+	engine.OnRegex(`^/regen(?:\s+(\S+))?$`, onDb).SetBlock(true).Handle(func(ctx *zero.Ctx) {
+		matched := ctx.State["regex_matched"].([]string)
+		uid := ctx.Event.UserID
+		if ctx.Event.GroupID > 0 {
+			uid = ctx.Event.GroupID
+		}
+
+		name := matched[1]
+		if name == "" {
+			name = Db.Config().Key
+		}
+
+		h, err := Db.LastHistory(uid, name)
+		if err != nil {
+			if IsSqlNull(err) {
+				ctx.Send(message.Text("没有可以重新生成的回复。"))
+				return
+			}
+			ctx.Send(message.Text("ERROR: ", err))
+			return
+		}
+
+		// 与 /edit 一样从上一轮开启新分支，旧回复保留，请求被丢弃时可以用 /fork 找回
+		if err = Db.Checkout(uid, name, h.Parent); err != nil {
+			ctx.Send(message.Text("ERROR: ", err))
+			return
+		}
+		submit(ctx, uid, name, historyPrompt(h), historyL)
+	})
+
+	engine.OnRegex(`^/continue(?:\s+(\S+))?$`, onDb).SetBlock(true).Handle(func(ctx *zero.Ctx) {
+		matched := ctx.State["regex_matched"].([]string)
+		uid := ctx.Event.UserID
+		if ctx.Event.GroupID > 0 {
+			uid = ctx.Event.GroupID
+		}
+
+		name := matched[1]
+		if name == "" {
+			name = Db.Config().Key
+		}
+
+		conv := loadConversation(uid, name)
+		conv.Lock()
+		truncated := conv.finishReason == "length"
+		conv.Unlock()
+		if !truncated {
+			ctx.Send(message.Text("上一条回复没有被截断，无需继续。"))
 			return
 		}
-		limiter := limitManager.Load(uid)
-		if !limiter.Acquire() {
-			logrus.Warnf("当前请求限流: %d", uid)
+		submit(ctx, uid, name, textPrompt("请从上次中断的地方继续。"), historyL)
+	})
+
+	engine.OnRegex(`^/history(?:\s+(\S+))?$`, onDb).SetBlock(true).Handle(func(ctx *zero.Ctx) {
+		matched := ctx.State["regex_matched"].([]string)
+		uid := ctx.Event.UserID
+		if ctx.Event.GroupID > 0 {
+			uid = ctx.Event.GroupID
+		}
+
+		name := matched[1]
+		if name == "" {
+			name = Db.Config().Key
+		}
+
+		histories, err := Db.FindHistory(uid, name, 10)
+		if err != nil && !IsSqlNull(err) {
+			ctx.Send(message.Text("ERROR: ", err))
 			return
 		}
 
-		histories, err := Db.findHistory(uid, matched[1], 100)
+		content := "***  history  ***\n"
+		for hL := len(histories) - 1; hL >= 0; hL-- {
+			h := histories[hL]
+			content += fmt.Sprintf("\n#%d [%s]\nQ: %s\nA: %s\n", h.Id, time.Unix(h.Timestamp, 0).Format("01-02 15:04"), abbr(historyPrompt(h).plain(), 40), abbr(h.AssistantContent, 40))
+		}
+		if len(histories) == 0 {
+			content += "\n   ~ none ~\n"
+		}
+
+		branches, err := Db.Branches(uid, name)
 		if err != nil && !IsSqlNull(err) {
 			ctx.Send(message.Text("ERROR: ", err))
 			return
 		}
+		if len(branches) > 0 {
+			content += "\n其它分支:"
+			for _, h := range branches {
+				content += fmt.Sprintf(" #%d", h.Id)
+			}
+			content += "\n使用 /fork id 切换"
+		}
+		ctx.Send(message.Text(content))
+	})
+
+	engine.OnRegex(`^/edit\s+(\d+)\s+([\s\S]+)$`, onDb).SetBlock(true).Handle(func(ctx *zero.Ctx) {
+		matched := ctx.State["regex_matched"].([]string)
+		uid := ctx.Event.UserID
+		if ctx.Event.GroupID > 0 {
+			uid = ctx.Event.GroupID
+		}
+
+		h, ok := ownHistory(ctx, uid, matched[1])
+		if !ok {
+			return
+		}
+
+		// 从该轮的上一轮开启新分支，原分支保留
+		if err := Db.Checkout(uid, h.Name, h.Parent); err != nil {
+			ctx.Send(message.Text("ERROR: ", err))
+			return
+		}
+		submit(ctx, uid, h.Name, textPrompt(strings.TrimSpace(matched[2])), historyL)
+	})
+
+	engine.OnRegex(`^/fork\s+(\d+)$`, onDb).SetBlock(true).Handle(func(ctx *zero.Ctx) {
+		matched := ctx.State["regex_matched"].([]string)
+		uid := ctx.Event.UserID
+		if ctx.Event.GroupID > 0 {
+			uid = ctx.Event.GroupID
+		}
+
+		h, ok := ownHistory(ctx, uid, matched[1])
+		if !ok {
+			return
+		}
+
+		if err := Db.Checkout(uid, h.Name, h.Id); err != nil {
+			ctx.Send(message.Text("ERROR: ", err))
+			return
+		}
+		ctx.Send(message.Text(fmt.Sprintf("已切换到 #%d，接下来的对话将从这里继续。", h.Id)))
+	})
+
+	engine.OnRegex(`^/export(?:\s+(\S+))?(?:\s+(json|md|jsonl))?$`, onDb).SetBlock(true).Handle(func(ctx *zero.Ctx) {
+		matched := ctx.State["regex_matched"].([]string)
+		uid := ctx.Event.UserID
+		if ctx.Event.GroupID > 0 {
+			uid = ctx.Event.GroupID
+		}
+
+		name, format := matched[1], matched[2]
+		if format == "" && Contains(export.Formats, name) {
+			name, format = "", name
+		}
+		if name == "" {
+			name = Db.Config().Key
+		}
+
+		file, err := exportHistories(uid, name, format)
+		if err != nil {
+			ctx.Send(message.Text("ERROR: ", err))
+			return
+		}
 
-		completions(ctx, uid, matched[1], msg, histories)
+		var resp zero.APIResponse
+		if ctx.Event.GroupID > 0 {
+			resp = ctx.UploadThisGroupFile(file, filepath.Base(file), "")
+		} else {
+			resp = ctx.CallAction("upload_private_file", zero.Params{
+				"user_id": ctx.Event.UserID,
+				"file":    file,
+				"name":    filepath.Base(file),
+			})
+		}
+		if resp.RetCode != 0 {
+			ctx.Send(message.Text("ERROR: ", resp.Msg, " ", resp.Wording))
+		}
+	})
+
+	engine.OnNotice(func(ctx *zero.Ctx) bool {
+		if ctx.Event.NoticeType != "group_upload" && ctx.Event.NoticeType != "offline_file" {
+			return false
+		}
+		return ctx.Event.File != nil && Contains(export.Formats, strings.TrimPrefix(filepath.Ext(ctx.Event.File.Name), "."))
+	}).Handle(rememberUpload)
+
+	engine.OnRegex(`^/import(?:\s+(\S+))?$`, zero.AdminPermission, onDb).SetBlock(true).Handle(func(ctx *zero.Ctx) {
+		matched := ctx.State["regex_matched"].([]string)
+		uid := ctx.Event.UserID
+		if ctx.Event.GroupID > 0 {
+			uid = ctx.Event.GroupID
+		}
+
+		name := matched[1]
+		if name == "" {
+			name = Db.Config().Key
+		}
+
+		u, ok := lastUpload(uid)
+		if !ok {
+			ctx.Send(message.Text("请先上传 json | md | jsonl 格式的对话记录文件。"))
+			return
+		}
+
+		data, err := download(u.url)
+		if err != nil {
+			ctx.Send(message.Text("ERROR: ", err))
+			return
+		}
+
+		turns, err := export.Parse(u.name, data)
+		if err != nil {
+			ctx.Send(message.Text("ERROR: ", err))
+			return
+		}
+
+		count, err := importHistories(uid, name, turns)
+		if err != nil {
+			ctx.Send(message.Text("ERROR: ", err))
+			return
+		}
+		ctx.Send(message.Text(fmt.Sprintf("已从 %s 导入 %d 轮对话。", u.name, count)))
 	})
 
 	engine.OnRegex(`^/clear\s+(\S+)`, zero.AdminPermission, onDb).SetBlock(true).
 		Handle(func(ctx *zero.Ctx) {
 			matched := ctx.State["regex_matched"].([]string)
-			if err := Db.cleanAllHistories(matched[1]); err != nil {
+			if err := Db.CleanAllHistories(matched[1]); err != nil {
 				ctx.Send(message.Text("ERROR: ", err))
 				return
 			}
@@ -282,42 +469,102 @@ func init() {
 	engine.OnRegex(`^/set-key\s+(\S+)\s+(\S+)$`, zero.AdminPermission, onDb).SetBlock(true).
 		Handle(func(ctx *zero.Ctx) {
 			matched := ctx.State["regex_matched"].([]string)
-			if err := Db.saveKey(Key{Name: matched[1], Content: matched[2]}); err != nil {
+			// 群里发出的 key 立即撤回
+			if ctx.Event.GroupID > 0 {
+				ctx.DeleteMessage(message.NewMessageIDFromString(fmt.Sprint(ctx.Event.MessageID)))
+			}
+
+			c := Db.Config()
+			health, err := probeKey(c, matched[2])
+			if health == model.HealthAuth {
+				ctx.Send(message.Text("key 校验失败，鉴权未通过: ", err))
+				return
+			}
+
+			k := model.Key{Name: matched[1], Content: matched[2], Provider: provider(c.BaseUrl), Model: c.Model, Health: health}
+			if err = Db.SaveKey(k); err != nil {
 				ctx.Send(message.Text("ERROR: ", err))
 				return
 			}
-			ctx.Send(message.Text("添加key成功。"))
+
+			switch health {
+			case model.HealthOk:
+				ctx.Send(message.Text("添加key成功，校验通过。"))
+			case model.HealthLimited:
+				ctx.Send(message.Text("添加key成功，校验通过，当前被限流。"))
+			default:
+				ctx.Send(message.Text("添加key成功，但校验请求失败 (网络错误或不支持 /v1/models): ", err))
+			}
 		})
 
 	engine.OnRegex(`^/del-key\s+(\S+)$`, zero.AdminPermission, onDb).SetBlock(true).
 		Handle(func(ctx *zero.Ctx) {
 			matched := ctx.State["regex_matched"].([]string)
-			if err := Db.delKey(matched[1]); err != nil {
+			if err := Db.DelKey(matched[1]); err != nil {
 				ctx.Send(message.Text("ERROR: ", err))
 				return
 			}
 			ctx.Send(message.Text("已删除该key。"))
 		})
 
-	engine.OnFullMatch("/keys", onDb).SetBlock(true).SetBlock(true).
+	engine.OnFullMatch("/keys", onDb).SetBlock(true).
 		Handle(func(ctx *zero.Ctx) {
-			ks, err := Db.keys()
+			ks, err := Db.Keys()
 			if err != nil && !IsSqlNull(err) {
 				ctx.Send(message.Text("ERROR: ", err))
 				return
 			}
-			c := Db.config()
+			c := Db.Config()
 			content := "***  keys  ***\n\n"
 
-			isEmpty := true
+			// 详细信息只给管理员看
+			admin := zero.AdminPermission(ctx)
 			for _, k := range ks {
-				if c.Key != k.Name {
-					content += k.Name + "\n"
-					isEmpty = false
+				mark := "  "
+				if c.Key == k.Name {
+					mark = "* "
+				}
+				content += mark + k.Name + "\n"
+				if admin {
+					content += formatKey(k)
+				}
+			}
+
+			if len(ks) == 0 {
+				content += "   ~ none ~"
+			} else {
+				content += "\n* 为默认key"
+			}
+			ctx.Send(message.Text(content))
+		})
+
+	engine.OnRegex(`^/models(?:\s+(\S+))?$`, onDb).SetBlock(true).
+		Handle(func(ctx *zero.Ctx) {
+			matched := ctx.State["regex_matched"].([]string)
+			name := matched[1]
+			if name == "" {
+				name = Db.Config().Key
+			}
+
+			models, err := listModels(name)
+			if err != nil {
+				if IsSqlNull(err) {
+					ctx.Send(message.Text("没有找到key: ", name))
+					return
 				}
+				ctx.Send(message.Text("ERROR: ", err))
+				return
 			}
 
-			if isEmpty {
+			content := "***  models  ***\n\n"
+			for i, m := range models {
+				if i == maxModels {
+					content += fmt.Sprintf("... 共 %d 个\n", len(models))
+					break
+				}
+				content += m + "\n"
+			}
+			if len(models) == 0 {
 				content += "   ~ none ~"
 			}
 			ctx.Send(message.Text(content))
@@ -325,23 +572,41 @@ func init() {
 
 	engine.OnFullMatch("/config", zero.AdminPermission, onDb).SetBlock(true).
 		Handle(func(ctx *zero.Ctx) {
-			c := Db.config()
+			c := Db.Config()
 			content := "***  config  ***\n\n"
 			content += "proxies: " + c.Proxies + "\n"
 			content += "baseUrl: " + c.BaseUrl + "\n"
 			content += "model: " + c.Model + "\n"
 			content += "Key: " + c.Key + "\n"
+			content += "emoji: " + emojiPolicy(c.Key).String() + "\n"
 			content += "imitate: " + strconv.FormatBool(c.Imitate) + "\n"
 			content += "freq: " + strconv.Itoa(c.Freq) + "%\n"
+			content += "weights: " + formatWeights(0) + "\n"
+			content += "schedule: " + schedule.Format(loadSchedules(0)) + "\n"
+			count, minutes := cooldownOption(0)
+			content += fmt.Sprintf("cooldown: %d/%dm\n", count, minutes)
+			size, minutes := chatOption()
+			content += fmt.Sprintf("messages: %d/%dm\n", size, minutes)
+			content += fmt.Sprintf("delivery: %s/%d\n", Db.Option("delivery", deliveryFinal), Db.OptionInt("chunk_size", chunkSize))
+			content += fmt.Sprintf("forward: %d\n", Db.OptionInt("forward_length", forwardLength))
+			content += "render: " + Db.Option("render", render.Auto) + "\n"
+			content += "latex: " + strconv.FormatBool(Db.OptionBool("latex", true)) + "\n"
+			content += fmt.Sprintf("typing: %d/s ±%d%%, merge %d\n", Db.OptionInt("typing_cps", typingCps), Db.OptionInt("typing_jitter", typingJitter), Db.OptionInt("typing_merge", typingMerge))
+			content += "queue: " + strconv.FormatBool(Db.OptionBool("queue", true)) + "\n"
+			content += "queueSize: " + strconv.Itoa(Db.OptionInt("queue_size", queueSize)) + "\n"
+			content += "concurrency: " + strconv.Itoa(Db.OptionInt("concurrency", concurrency)) + "\n"
+			content += "retentionDays: " + strconv.Itoa(Db.OptionInt("retention_days", 0)) + "\n"
+			content += "retentionRows: " + strconv.Itoa(Db.OptionInt("retention_rows", 0)) + "\n"
+			content += "vacuum: " + strconv.Itoa(Db.OptionInt("vacuum_hours", vacuumHours)) + "h\n"
 			ctx.Send(message.Text(content))
 		})
 
 	engine.OnRegex(`^/config\.proxies\s*(\S*)$`, zero.AdminPermission, onDb).SetBlock(true).
 		Handle(func(ctx *zero.Ctx) {
 			matched := ctx.State["regex_matched"].([]string)
-			c := Db.config()
+			c := Db.Config()
 			c.Proxies = matched[1]
-			err := Db.updateConfig(c)
+			err := Db.UpdateConfig(c)
 			if err != nil {
 				ctx.Send(message.Text("ERROR: ", err))
 				return
@@ -352,9 +617,9 @@ func init() {
 	engine.OnRegex(`^/config\.baseUrl\s+(\S+)$`, zero.AdminPermission, onDb).SetBlock(true).
 		Handle(func(ctx *zero.Ctx) {
 			matched := ctx.State["regex_matched"].([]string)
-			c := Db.config()
+			c := Db.Config()
 			c.BaseUrl = matched[1]
-			err := Db.updateConfig(c)
+			err := Db.UpdateConfig(c)
 			if err != nil {
 				ctx.Send(message.Text("ERROR: ", err))
 				return
@@ -365,10 +630,21 @@ func init() {
 	engine.OnRegex(`^/config\.model\s+(\S+)$`, zero.AdminPermission, onDb).SetBlock(true).
 		Handle(func(ctx *zero.Ctx) {
 			matched := ctx.State["regex_matched"].([]string)
-			c := Db.config()
+			c := Db.Config()
+
+			// 能取到模型列表时校验，取不到的照常保存
+			models, err := listModels(c.Key)
+			if err == nil && len(models) > 0 && !slices.Contains(models, matched[1]) {
+				content := "没有找到模型 " + matched[1]
+				if suggestions := suggestModels(models, matched[1], 3); len(suggestions) > 0 {
+					content += "，你是不是要找:\n" + strings.Join(suggestions, "\n")
+				}
+				ctx.Send(message.Text(content))
+				return
+			}
+
 			c.Model = matched[1]
-			err := Db.updateConfig(c)
-			if err != nil {
+			if err = Db.UpdateConfig(c); err != nil {
 				ctx.Send(message.Text("ERROR: ", err))
 				return
 			}
@@ -378,9 +654,9 @@ func init() {
 	engine.OnRegex(`^/config\.Key\s+(\S+)$`, zero.AdminPermission, onDb).SetBlock(true).
 		Handle(func(ctx *zero.Ctx) {
 			matched := ctx.State["regex_matched"].([]string)
-			c := Db.config()
+			c := Db.Config()
 			c.Key = matched[1]
-			err := Db.updateConfig(c)
+			err := Db.UpdateConfig(c)
 			if err != nil {
 				ctx.Send(message.Text("ERROR: ", err))
 				return
@@ -391,7 +667,7 @@ func init() {
 	engine.OnRegex(`^/config\.imitate\s(true|false)$`, zero.AdminPermission, onDb).SetBlock(true).
 		Handle(func(ctx *zero.Ctx) {
 			matched := ctx.State["regex_matched"].([]string)
-			c := Db.config()
+			c := Db.Config()
 			tex := "关闭"
 			if matched[1] == "true" {
 				c.Imitate = true
@@ -401,7 +677,7 @@ func init() {
 				tex = "关闭"
 			}
 
-			if err := Db.updateConfig(c); err != nil {
+			if err := Db.UpdateConfig(c); err != nil {
 				ctx.Send(message.Text("ERROR: ", err))
 				return
 			}
@@ -412,7 +688,7 @@ func init() {
 	engine.OnRegex(`^/config.freq\s(\d+)`, zero.AdminPermission, onDb).SetBlock(true).
 		Handle(func(ctx *zero.Ctx) {
 			matched := ctx.State["regex_matched"].([]string)
-			c := Db.config()
+			c := Db.Config()
 			i, err := strconv.Atoi(matched[1])
 			if err != nil {
 				ctx.Send(message.Text("ERROR: ", err))
@@ -425,13 +701,360 @@ func init() {
 			}
 
 			c.Freq = i
-			if err = Db.updateConfig(c); err != nil {
+			if err = Db.UpdateConfig(c); err != nil {
 				ctx.Send(message.Text("ERROR: ", err))
 				return
 			}
 
 			ctx.Send(message.Text("已修改回复频率为 " + matched[1] + "%。"))
 		})
+
+	engine.OnRegex(`^/config\.weight\s(\w+)\s(-?\d+)(?:\s+(\d+))?$`, zero.AdminPermission, onDb).SetBlock(true).
+		Handle(func(ctx *zero.Ctx) {
+			matched := ctx.State["regex_matched"].([]string)
+			if !slices.Contains(scoreSignals, matched[1]) {
+				ctx.Send(message.Text("可选的信号: " + strings.Join(scoreSignals, ", ")))
+				return
+			}
+
+			i, err := strconv.Atoi(matched[2])
+			if err != nil || i < -100 || i > 100 {
+				ctx.Send(message.Text("取值范围限制在-100~100！"))
+				return
+			}
+
+			name := "weight." + matched[1]
+			if matched[3] != "" {
+				name += "." + matched[3]
+			}
+			if err = Db.SetOption(name, matched[2]); err != nil {
+				ctx.Send(message.Text("ERROR: ", err))
+				return
+			}
+			ctx.Send(message.Text("已修改 " + matched[1] + " 权重为 " + matched[2] + "。"))
+		})
+
+	engine.OnRegex(`^/config\.keywords\s(\S+)(?:\s+(\d+))?$`, zero.AdminPermission, onDb).SetBlock(true).
+		Handle(func(ctx *zero.Ctx) {
+			matched := ctx.State["regex_matched"].([]string)
+			name := "keywords"
+			if matched[2] != "" {
+				name += "." + matched[2]
+			}
+
+			// - 表示清空
+			value := matched[1]
+			if value == "-" {
+				value = ""
+			}
+			if err := Db.SetOption(name, value); err != nil {
+				ctx.Send(message.Text("ERROR: ", err))
+				return
+			}
+			ctx.Send(message.Text("已更新自由发言关键词。"))
+		})
+
+	engine.OnRegex(`^/config\.schedule\s(\S+)(?:\s+(\d+))?$`, zero.AdminPermission, onDb).SetBlock(true).
+		Handle(func(ctx *zero.Ctx) {
+			matched := ctx.State["regex_matched"].([]string)
+			name := "schedule"
+			if matched[2] != "" {
+				name += "." + matched[2]
+			}
+
+			// - 表示清空
+			value := matched[1]
+			if value == "-" {
+				value = ""
+			}
+			schedules, err := schedule.Parse(value)
+			if err != nil {
+				ctx.Send(message.Text("ERROR: ", err, "\n示例: /config.schedule 23:00-07:00=0,12:00-13:30=50"))
+				return
+			}
+
+			if err = Db.SetOption(name, value); err != nil {
+				ctx.Send(message.Text("ERROR: ", err))
+				return
+			}
+			ctx.Send(message.Text("已更新自由发言时段: ", schedule.Format(schedules)))
+		})
+
+	engine.OnRegex(`^/config\.cooldown\s(\d+)\s(\d+)(?:\s+(\d+))?$`, zero.AdminPermission, onDb).SetBlock(true).
+		Handle(func(ctx *zero.Ctx) {
+			matched := ctx.State["regex_matched"].([]string)
+			suffix := ""
+			if matched[3] != "" {
+				suffix = "." + matched[3]
+			}
+
+			if err := Db.SetOption("cooldown"+suffix, matched[1]); err != nil {
+				ctx.Send(message.Text("ERROR: ", err))
+				return
+			}
+			if err := Db.SetOption("cooldown_minutes"+suffix, matched[2]); err != nil {
+				ctx.Send(message.Text("ERROR: ", err))
+				return
+			}
+
+			if matched[1] == "0" {
+				ctx.Send(message.Text("已关闭连续发言冷却。"))
+				return
+			}
+			ctx.Send(message.Text("连续自由发言 " + matched[1] + " 次后冷却 " + matched[2] + " 分钟。"))
+		})
+
+	engine.OnRegex(`^/config\.messages\s(\d+)\s(\d+)$`, zero.AdminPermission, onDb).SetBlock(true).
+		Handle(func(ctx *zero.Ctx) {
+			matched := ctx.State["regex_matched"].([]string)
+			if matched[1] == "0" {
+				ctx.Send(message.Text("缓存条数至少为1！"))
+				return
+			}
+
+			if err := Db.SetOption("messages", matched[1]); err != nil {
+				ctx.Send(message.Text("ERROR: ", err))
+				return
+			}
+			if err := Db.SetOption("messages_minutes", matched[2]); err != nil {
+				ctx.Send(message.Text("ERROR: ", err))
+				return
+			}
+
+			resizeChats()
+			ctx.Send(message.Text("自由发言参考最近 " + matched[1] + " 条、" + matched[2] + " 分钟内的消息。"))
+		})
+
+	engine.OnRegex(`^/config\.format\s+(\S+)\s+(text|structured)$`, zero.AdminPermission, onDb).SetBlock(true).
+		Handle(func(ctx *zero.Ctx) {
+			matched := ctx.State["regex_matched"].([]string)
+			if _, err := Db.Key(matched[1]); err != nil {
+				if IsSqlNull(err) {
+					ctx.Send(message.Text("没有找到key: ", matched[1]))
+					return
+				}
+				ctx.Send(message.Text("ERROR: ", err))
+				return
+			}
+
+			if err := Db.SetOption("format."+matched[1], matched[2]); err != nil {
+				ctx.Send(message.Text("ERROR: ", err))
+				return
+			}
+			ctx.Send(message.Text("已修改 " + matched[1] + " 的群聊消息格式为 " + matched[2] + "。"))
+		})
+
+	engine.OnRegex(`^/config\.emoji\s+(\S+)\s+(.+)$`, zero.AdminPermission, onDb).SetBlock(true).
+		Handle(func(ctx *zero.Ctx) {
+			matched := ctx.State["regex_matched"].([]string)
+			if _, err := Db.Key(matched[1]); err != nil {
+				if IsSqlNull(err) {
+					ctx.Send(message.Text("没有找到key: ", matched[1]))
+					return
+				}
+				ctx.Send(message.Text("ERROR: ", err))
+				return
+			}
+
+			policy, err := emojis.Parse(matched[2])
+			if err != nil {
+				ctx.Send(message.Text("ERROR: ", err))
+				return
+			}
+
+			if err = Db.SetOption("emoji."+matched[1], policy.String()); err != nil {
+				ctx.Send(message.Text("ERROR: ", err))
+				return
+			}
+			ctx.Send(message.Text("已修改 " + matched[1] + " 的表情策略为 " + policy.String() + "。"))
+		})
+
+	engine.OnRegex(`^/config\.typing\s(\d+)\s(\d+)\s(\d+)$`, zero.AdminPermission, onDb).SetBlock(true).
+		Handle(func(ctx *zero.Ctx) {
+			matched := ctx.State["regex_matched"].([]string)
+			for i, name := range []string{"typing_cps", "typing_jitter", "typing_merge"} {
+				if err := Db.SetOption(name, matched[i+1]); err != nil {
+					ctx.Send(message.Text("ERROR: ", err))
+					return
+				}
+			}
+
+			if matched[1] == "0" {
+				ctx.Send(message.Text("已关闭打字节奏模拟。"))
+				return
+			}
+			ctx.Send(message.Text("已修改打字节奏: 每秒 " + matched[1] + " 字，浮动 " + matched[2] + "%，合并短于 " + matched[3] + " 字的片段。"))
+		})
+
+	engine.OnRegex(`^/config\.delivery\s(final|progressive)(?:\s+(\d+))?$`, zero.AdminPermission, onDb).SetBlock(true).
+		Handle(func(ctx *zero.Ctx) {
+			matched := ctx.State["regex_matched"].([]string)
+			if err := Db.SetOption("delivery", matched[1]); err != nil {
+				ctx.Send(message.Text("ERROR: ", err))
+				return
+			}
+			if matched[2] != "" {
+				if err := Db.SetOption("chunk_size", matched[2]); err != nil {
+					ctx.Send(message.Text("ERROR: ", err))
+					return
+				}
+			}
+
+			if matched[1] == deliveryFinal {
+				ctx.Send(message.Text("已修改为生成结束后一次发送。"))
+				return
+			}
+			ctx.Send(message.Text(fmt.Sprintf("已修改为分段发送，每段至少 %d 字。", Db.OptionInt("chunk_size", chunkSize))))
+		})
+
+	engine.OnRegex(`^/config\.forward\s(\d+)$`, zero.AdminPermission, onDb).SetBlock(true).
+		Handle(func(ctx *zero.Ctx) {
+			matched := ctx.State["regex_matched"].([]string)
+			if err := Db.SetOption("forward_length", matched[1]); err != nil {
+				ctx.Send(message.Text("ERROR: ", err))
+				return
+			}
+
+			if matched[1] == "0" {
+				ctx.Send(message.Text("已关闭长回复合并转发。"))
+				return
+			}
+			ctx.Send(message.Text("超过 " + matched[1] + " 字的回复将以合并转发发送。"))
+		})
+
+	engine.OnRegex(`^/config\.render\s(auto|always|off)$`, zero.AdminPermission, onDb).SetBlock(true).
+		Handle(func(ctx *zero.Ctx) {
+			matched := ctx.State["regex_matched"].([]string)
+			if err := Db.SetOption("render", matched[1]); err != nil {
+				ctx.Send(message.Text("ERROR: ", err))
+				return
+			}
+			ctx.Send(message.Text("已修改回复渲染方式为 " + matched[1] + "。"))
+		})
+
+	engine.OnRegex(`^/config\.latex\s(true|false)$`, zero.AdminPermission, onDb).SetBlock(true).
+		Handle(func(ctx *zero.Ctx) {
+			matched := ctx.State["regex_matched"].([]string)
+			if err := Db.SetOption("latex", matched[1]); err != nil {
+				ctx.Send(message.Text("ERROR: ", err))
+				return
+			}
+			if matched[1] == "true" {
+				ctx.Send(message.Text("已开启公式渲染。"))
+				return
+			}
+			ctx.Send(message.Text("已关闭公式渲染。"))
+		})
+
+	engine.OnRegex(`^/config\.queue\s(true|false)$`, zero.AdminPermission, onDb).SetBlock(true).
+		Handle(func(ctx *zero.Ctx) {
+			matched := ctx.State["regex_matched"].([]string)
+			if err := Db.SetOption("queue", matched[1]); err != nil {
+				ctx.Send(message.Text("ERROR: ", err))
+				return
+			}
+
+			tex := "关闭"
+			if matched[1] == "true" {
+				tex = "开启"
+			}
+			ctx.Send(message.Text("已" + tex + "请求排队。"))
+		})
+
+	engine.OnRegex(`^/config\.queueSize\s(\d+)$`, zero.AdminPermission, onDb).SetBlock(true).
+		Handle(func(ctx *zero.Ctx) {
+			matched := ctx.State["regex_matched"].([]string)
+			if err := Db.SetOption("queue_size", matched[1]); err != nil {
+				ctx.Send(message.Text("ERROR: ", err))
+				return
+			}
+			ctx.Send(message.Text("已修改排队上限为 " + matched[1] + "。"))
+		})
+
+	engine.OnRegex(`^/config\.concurrency\s(\d+)(?:\s+(\S+))?$`, zero.AdminPermission, onDb).SetBlock(true).
+		Handle(func(ctx *zero.Ctx) {
+			matched := ctx.State["regex_matched"].([]string)
+			i, err := strconv.Atoi(matched[1])
+			if err != nil {
+				ctx.Send(message.Text("ERROR: ", err))
+				return
+			}
+
+			name := "concurrency"
+			if matched[2] != "" {
+				name += "." + matched[2]
+			}
+
+			if err = Db.SetOption(name, matched[1]); err != nil {
+				ctx.Send(message.Text("ERROR: ", err))
+				return
+			}
+
+			if matched[2] != "" {
+				loadKeyStreams(matched[2]).Resize(int64(i))
+				ctx.Send(message.Text("已修改 " + matched[2] + " 并发上限为 " + matched[1] + "。"))
+				return
+			}
+			loadStreams().Resize(int64(i))
+			ctx.Send(message.Text("已修改并发上限为 " + matched[1] + "。"))
+		})
+
+	engine.OnRegex(`^/config\.(retentionDays|retentionRows|vacuum)\s(\d+)$`, zero.AdminPermission, onDb).SetBlock(true).
+		Handle(func(ctx *zero.Ctx) {
+			matched := ctx.State["regex_matched"].([]string)
+			name := map[string]string{
+				"retentionDays": "retention_days",
+				"retentionRows": "retention_rows",
+				"vacuum":        "vacuum_hours",
+			}[matched[1]]
+
+			if err := Db.SetOption(name, matched[2]); err != nil {
+				ctx.Send(message.Text("ERROR: ", err))
+				return
+			}
+			ctx.Send(message.Text("已修改 " + matched[1] + " 为 " + matched[2] + "。"))
+		})
+
+	engine.OnFullMatch("/db", zero.AdminPermission, onDb).SetBlock(true).
+		Handle(func(ctx *zero.Ctx) {
+			usages, err := Db.Usages(20)
+			if err != nil {
+				ctx.Send(message.Text("ERROR: ", err))
+				return
+			}
+
+			content := "***  db  ***\n\n"
+			if db, ok := Db.(*model.DB); ok {
+				if info, e := os.Stat(db.Path()); e == nil {
+					content += "size: " + formatBytes(info.Size()) + "\n\n"
+				}
+			}
+			for _, u := range usages {
+				content += fmt.Sprintf("%d: %d 条, %s\n", u.Uid, u.Rows, formatBytes(u.Bytes))
+			}
+			if len(usages) == 0 {
+				content += "   ~ none ~"
+			}
+			ctx.Send(message.Text(content))
+		})
+
+	engine.OnFullMatch("/status", onDb).SetBlock(true).
+		Handle(func(ctx *zero.Ctx) {
+			cur, size, waiting, wait := loadStreams().Status()
+			content := "***  status  ***\n\n"
+			content += fmt.Sprintf("streams: %d/%s\n", cur, formatSize(size))
+			content += fmt.Sprintf("waiting: %d\n", waiting)
+			content += "wait: " + wait.Truncate(time.Second).String() + "\n"
+
+			keyStreamMu.Lock()
+			names := sortedKeys(keyStreams)
+			keyStreamMu.Unlock()
+			for _, name := range names {
+				cur, size, waiting, wait = loadKeyStreams(name).Status()
+				content += fmt.Sprintf("\n[%s] streams: %d/%s, waiting: %d, wait: %s", name, cur, formatSize(size), waiting, wait.Truncate(time.Second))
+			}
+			ctx.Send(message.Text(content))
+		})
 }
 
 // 消息体转换成纯文本内容
@@ -462,6 +1085,100 @@ func ExtPlainMessage(ctx *zero.Ctx) string {
 	return result
 }
 
+// 查找属于当前聊天室的对话记录
+func ownHistory(ctx *zero.Ctx, uid int64, id string) (*model.History, bool) {
+	i, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		ctx.Send(message.Text("ERROR: ", err))
+		return nil, false
+	}
+
+	h, err := Db.History(i)
+	if err != nil || h.Uid != uid {
+		if err != nil && !IsSqlNull(err) {
+			ctx.Send(message.Text("ERROR: ", err))
+			return nil, false
+		}
+		ctx.Send(message.Text("没有找到 #" + id + " 的对话记录。"))
+		return nil, false
+	}
+	return h, true
+}
+
+// 截取前 n 个字符
+func abbr(s string, n int) string {
+	s = strings.ReplaceAll(strings.TrimSpace(s), "\n", " ")
+	if r := []rune(s); len(r) > n {
+		return string(r[:n]) + "..."
+	}
+	return s
+}
+
+func formatKey(k *model.Key) string {
+	content := "    " + k.Hint
+	if k.Provider != "" {
+		content += "  " + k.Provider
+	}
+	if k.Model != "" {
+		content += "  " + k.Model
+	}
+	content += "\n    创建: " + formatTime(k.Created) + "  最近使用: " + formatTime(k.LastUsed)
+	if k.Health != "" {
+		content += "  状态: " + healthNames[k.Health]
+	}
+	return content + "\n"
+}
+
+var healthNames = map[string]string{
+	model.HealthOk:      "正常",
+	model.HealthAuth:    "鉴权失败",
+	model.HealthLimited: "限流",
+	model.HealthError:   "请求失败",
+}
+
+func formatTime(t int64) string {
+	if t <= 0 {
+		return "-"
+	}
+	return time.Unix(t, 0).Format("2006-01-02 15:04")
+}
+
+// 请求地址的域名
+func provider(baseUrl string) string {
+	u, err := url.Parse(baseUrl)
+	if err != nil || u.Host == "" {
+		return baseUrl
+	}
+	return u.Host
+}
+
+func formatBytes(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%dB", size)
+	}
+}
+
+func formatSize(size int64) string {
+	if size <= 0 {
+		return "∞"
+	}
+	return strconv.FormatInt(size, 10)
+}
+
+// 当前聊天室是否有进行中的对话
+func generating(ctx *zero.Ctx) bool {
+	uid := ctx.Event.UserID
+	if ctx.Event.GroupID > 0 {
+		uid = ctx.Event.GroupID
+	}
+	return isGenerating(uid)
+}
+
 func IsSqlNull(err error) bool {
-	return err != nil && err.Error() == "sqlite: null result"
+	return model.IsNull(err)
 }
@@ -8,6 +8,8 @@ import (
 	"errors"
 	"fmt"
 	"github.com/bincooo/emit.io"
+	"github.com/bincooo/zerobot-llm/emojis"
+	"github.com/bincooo/zerobot-llm/model"
 	"github.com/sirupsen/logrus"
 	zero "github.com/wdvxdr1123/ZeroBot"
 	"github.com/wdvxdr1123/ZeroBot/message"
@@ -15,9 +17,8 @@ import (
 	"math/rand"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
-
-	"github.com/bincooo/go.emoji"
 )
 
 type Request struct {
@@ -47,6 +48,14 @@ type Response struct {
 	} `json:"error"`
 }
 
+// 进行中的对话，/stop 时取消
+type generation struct {
+	cancel       context.CancelFunc
+	stop         chan struct{} // /stop 时关闭
+	stopped      bool
+	finishReason string
+}
+
 type Choice struct {
 	Index int `json:"index"`
 	Delta *struct {
@@ -60,30 +69,27 @@ var (
 	FEPrefix = []byte(`{"message":`)
 
 	limit = time.Now().Add(-100 * time.Second)
+
+	generations  = make(map[int64][]*generation)
+	generationMu sync.Mutex
 )
 
-// 对话
-func completions(ctx *zero.Ctx, uid int64, name, content string, histories []*History) {
+// 对话。模仿模式返回等待片段发送完的函数，调用方释放上游连接名额后、释放对话前调用
+func completions(ctx *zero.Ctx, uid int64, name string, content prompt, histories []*model.History) (wait func()) {
 	logrus.Infof("开始对话 [%d] ...", uid)
 	messages := make([]map[string]string, 0)
 	for hL := len(histories) - 1; hL >= 0; hL-- {
 		h := histories[hL]
-		messages = append(messages, map[string]string{
-			"role":    "user",
-			"content": h.UserContent,
-		})
+		messages = append(messages, userMessages(historyPrompt(h))...)
 		messages = append(messages, map[string]string{
 			"role":    "assistant",
 			"content": h.AssistantContent,
 		})
 	}
 
-	messages = append(messages, map[string]string{
-		"role":    "user",
-		"content": content,
-	})
+	messages = append(messages, userMessages(content)...)
 
-	c := Db.config()
+	c := Db.Config()
 	im := false
 	if c.Key == name {
 		im = c.Imitate
@@ -103,37 +109,67 @@ func completions(ctx *zero.Ctx, uid int64, name, content string, histories []*Hi
 		Stream:      true,
 	}
 
-	k, err := Db.key(name)
+	k, err := Db.Key(name)
 	if err != nil {
 		ctx.Send(message.Text("ERROR: Key query -> ", err))
 		return
 	}
 
+	secret, err := model.Decrypt(k)
+	if err != nil {
+		ctx.Send(message.Text("ERROR: Key decrypt -> ", err))
+		return
+	}
+
 	timeout, cancel := context.WithTimeout(context.Background(), 180*time.Second)
 	defer cancel()
 
+	gen := addGeneration(uid, cancel)
+	// 模仿模式的片段发送完之前保留，/stop 仍然可以中断
+	defer func() {
+		if wait == nil {
+			removeGeneration(uid, gen)
+		}
+	}()
+
+	start := time.Now()
+	defer func() { requestSeconds.Observe(time.Since(start).Seconds(), name, c.Model) }()
+
 	response, err := emit.ClientBuilder().
 		Context(timeout).
 		Proxies(c.Proxies).
 		POST(c.BaseUrl+"/v1/chat/completions").
 		JHeader().
-		Header("Authorization", "Bearer "+k.Content).
+		Header("Authorization", "Bearer "+secret).
 		Body(payload).
 		DoC(emit.Status(http.StatusOK), emit.IsSTREAM)
 	if err != nil {
+		// 响应头返回前被 /stop 中断，不算请求失败，也不影响 key 的状态
+		if gen.isStopped() {
+			logrus.Infof("对话已中断 [%d] .", uid)
+			return
+		}
+
 		errStr := err.Error()
 		if strings.Contains(errStr, "429 Too Many Requests") || strings.Contains(errStr, "400 Bad Request") {
 			limit.Add(60 * time.Second)
 		}
 		// ctx.Send(message.Text("ERROR: ", err))
 		logrus.Error(err)
+		requestsTotal.Inc(name, c.Model, "http_error")
+		_ = Db.UseKey(name, c.Model, keyHealth(err))
 		return
 	}
+	_ = Db.UseKey(name, c.Model, model.HealthOk)
+
+	activeStreams.Inc()
+	defer activeStreams.Dec()
 
 	ch := make(chan string)
-	go resolve(response, ch)
+	go resolve(response, ch, gen, newObserver(start, name, c.Model))
 
 	result := ""
+	policy := emojiPolicy(name)
 	if !im {
 		var messageID message.MessageID
 		if zero.OnlyPrivate(ctx) {
@@ -142,48 +178,87 @@ func completions(ctx *zero.Ctx, uid int64, name, content string, histories []*Hi
 			messageID = ctx.SendChain(message.Reply(ctx.Event.MessageID), message.Text("正在响应..."))
 		}
 
-		result, err = waitResponse(ch)
-		ctx.DeleteMessage(messageID)
+		rp := &replier{ctx: ctx}
+		reply := ""
+		if Db.Option("delivery", deliveryFinal) == deliveryProgressive {
+			result, reply, err = progressiveResponse(rp, ch, messageID, Db.OptionInt("chunk_size", chunkSize), policy)
+		} else {
+			result, err = waitResponse(ch)
+			result = policy.Apply(result)
+			reply = result
+		}
+
+		if !rp.sent {
+			ctx.DeleteMessage(messageID)
+		}
 		if err != nil {
+			requestsTotal.Inc(name, c.Model, "error")
 			ctx.Send(message.Text("ERROR: ", err))
 			return
 		}
 
-		if zero.OnlyPrivate(ctx) {
-			ctx.SendChain(message.Text(result))
-		} else {
-			ctx.SendChain(message.Reply(ctx.Event.MessageID), message.Text(result))
+		if gen.isStopped() {
+			reply = strings.TrimSpace(reply) + "\n\n[已中断]"
+		} else if gen.finish() == "length" {
+			reply = strings.TrimSpace(reply) + "\n\n[回复过长已截断，发送 /continue 继续]"
+		}
+
+		if reply = strings.TrimSpace(reply); reply != "" {
+			if rp.sent {
+				rp.send(reply)
+			} else {
+				rp.sendLong(reply)
+			}
 		}
-		return
 	} else {
-		result, err = batchResponse(ctx, ch, []string{"!", "...", ".", "！", "。。。", "。", "\n\n"}, []string{".", "。", "\n\n"})
+		// 模拟打字的等待在 typist 的协程里，这里读完就返回，释放超时和并发名额
+		t := newTypist(ctx, gen.stop)
+		defer t.close()
+		wait = func() {
+			t.wait()
+			removeGeneration(uid, gen)
+		}
+
+		result, err = batchResponse(ctx, t, ch, policy, []string{"!", "...", ".", "！", "。。。", "。", "\n\n"}, []string{".", "。", "\n\n"})
 		if err != nil {
+			requestsTotal.Inc(name, c.Model, "error")
 			ctx.Send(message.Text("ERROR: ", err))
 			return
 		}
 	}
 
+	markSpoke(uid)
 	if strings.TrimSpace(result) == "Oops" {
 		logrus.Warn("completions Oops.")
+		requestsTotal.Inc(name, c.Model, "oops")
 		return
 	}
+	requestsTotal.Inc(name, c.Model, "ok")
 
-	err = Db.saveHistory(History{
+	conv := loadConversation(uid, name)
+	conv.Lock()
+	conv.finishReason = gen.finish()
+	conv.Unlock()
+
+	err = Db.SaveHistory(model.History{
 		Timestamp:        time.Now().Unix(),
 		Uid:              uid,
 		Name:             name,
-		UserContent:      content,
+		UserContent:      content.content,
 		AssistantContent: result,
+		Format:           content.format,
 	})
 	if err != nil {
 		ctx.Send(message.Text("ERROR: ", err))
 	}
 	logrus.Infof("结束对话 [%d] .", uid)
+	return
 }
 
-func batchResponse(ctx *zero.Ctx, ch chan string, symbols []string, igSymbols []string) (result string, err error) {
+// 表情策略按片段处理，片段都在标点处切开，不会拆开 emoji
+func batchResponse(ctx *zero.Ctx, t *typist, ch chan string, policy emojis.Policy, symbols []string, igSymbols []string) (result string, err error) {
 	buf := ""
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	f := policy.Filter()
 
 	for {
 		toAt := ctx.Event.IsToMe
@@ -193,14 +268,11 @@ func batchResponse(ctx *zero.Ctx, ch chan string, symbols []string, igSymbols []
 
 		text, ok := <-ch
 		if !ok {
-			if tex := strings.TrimSpace(buf); tex != "" {
-				if toAt {
-					ctx.SendChain(message.Reply(ctx.Event.MessageID), message.Text(tex))
-				} else {
-					ctx.SendChain(message.Text(tex))
-				}
+			if tex := strings.TrimSpace(f.Apply(buf)); tex != "" {
+				t.send(tex, toAt)
 			}
-			return
+			t.flush(toAt)
+			return policy.Apply(result), nil
 		}
 
 		if strings.HasPrefix(text, "error: ") {
@@ -209,9 +281,7 @@ func batchResponse(ctx *zero.Ctx, ch chan string, symbols []string, igSymbols []
 
 		text = strings.TrimPrefix(text, "text: ")
 		buf += text
-		buf = cleanEmoji(buf, r.Intn(3) > 0)
 		result += text
-		result = cleanEmoji(result, r.Intn(3) > 0)
 
 		for _, symbol := range symbols {
 			index := strings.Index(buf, symbol)
@@ -221,11 +291,8 @@ func batchResponse(ctx *zero.Ctx, ch chan string, symbols []string, igSymbols []
 					l = len(symbol)
 				}
 
-				tex := strings.TrimSpace(buf[:index+l])
-				if tex != "" && toAt {
-					ctx.SendChain(message.Reply(ctx.Event.MessageID), message.Text(tex))
-				} else {
-					ctx.SendChain(message.Text(tex))
+				if tex := strings.TrimSpace(f.Apply(buf[:index+l])); tex != "" {
+					t.send(tex, toAt)
 				}
 				buf = buf[index+len(symbol):]
 			}
@@ -247,13 +314,10 @@ func waitResponse(ch chan string) (result string, err error) {
 		text = strings.TrimPrefix(text, "text: ")
 		result += text
 	}
-
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	result = cleanEmoji(result, r.Intn(3) > 0)
 	return
 }
 
-func resolve(response *http.Response, ch chan string) {
+func resolve(response *http.Response, ch chan string, gen *generation, obs *observer) {
 	defer close(ch)
 	r := bufio.NewReader(response.Body)
 	before := []byte("data: ")
@@ -263,7 +327,8 @@ func resolve(response *http.Response, ch chan string) {
 	for {
 		line, prefix, err := r.ReadLine()
 		if err != nil {
-			if err != io.EOF {
+			// 被 /stop 中断时保留已生成的内容
+			if err != io.EOF && !gen.isStopped() {
 				ch <- fmt.Sprintf("error: %v", err)
 			}
 			return
@@ -314,41 +379,89 @@ func resolve(response *http.Response, ch chan string) {
 		}
 
 		if len(res.Choices) > 0 {
-			ch <- fmt.Sprintf("text: %s", res.Choices[0].Delta.Content)
+			choice := res.Choices[0]
+			if choice.FinishReason != "" {
+				gen.finish(choice.FinishReason)
+			}
+			if choice.Delta != nil {
+				obs.onText()
+				ch <- fmt.Sprintf("text: %s", choice.Delta.Content)
+			}
 		}
 		data = nil
 	}
 }
 
-// 只保留一个emoji, flag 为true时不保留
-func cleanEmoji(raw string, flag bool) string {
-	var (
-		pos      int
-		previous string
-	)
-
-	return emoji.ReplaceEmoji(raw, func(index int, emoji string) string {
-		if index-len(emoji) != pos {
-			previous = emoji
-			pos = index
-			if flag {
-				return ""
-			}
-			return emoji
-		}
+func addGeneration(uid int64, cancel context.CancelFunc) *generation {
+	generationMu.Lock()
+	defer generationMu.Unlock()
+	gen := &generation{cancel: cancel, stop: make(chan struct{})}
+	generations[uid] = append(generations[uid], gen)
+	return gen
+}
 
-		if emoji == previous {
-			pos = index
-			return ""
+func removeGeneration(uid int64, gen *generation) {
+	generationMu.Lock()
+	defer generationMu.Unlock()
+	gens := generations[uid]
+	for i, g := range gens {
+		if g == gen {
+			gens = append(gens[:i], gens[i+1:]...)
+			break
 		}
+	}
 
-		previous = emoji
-		pos = index
-		if flag {
-			return ""
+	if len(gens) == 0 {
+		delete(generations, uid)
+	} else {
+		generations[uid] = gens
+	}
+}
+
+// 中断该聊天室所有进行中的对话
+func stopGeneration(uid int64) bool {
+	generationMu.Lock()
+	defer generationMu.Unlock()
+	gens := generations[uid]
+	for _, gen := range gens {
+		if !gen.stopped {
+			close(gen.stop)
 		}
-		return emoji
-	})
+		gen.stopped = true
+		gen.cancel()
+	}
+	return len(gens) > 0
+}
+
+func isGenerating(uid int64) bool {
+	generationMu.Lock()
+	defer generationMu.Unlock()
+	return len(generations[uid]) > 0
+}
+
+func (gen *generation) isStopped() bool {
+	generationMu.Lock()
+	defer generationMu.Unlock()
+	return gen.stopped
+}
+
+// 读取 | 记录结束原因
+func (gen *generation) finish(reason ...string) string {
+	generationMu.Lock()
+	defer generationMu.Unlock()
+	if len(reason) > 0 {
+		gen.finishReason = reason[0]
+	}
+	return gen.finishReason
+}
+
+// 每个 key (人设) 单独设置 emoji.<key>，默认 collapse
+func emojiPolicy(name string) emojis.Policy {
+	p, err := emojis.Parse(Db.Option("emoji."+name, emojis.Collapse))
+	if err != nil {
+		return emojis.Policy{Mode: emojis.Collapse}
+	}
+	return p
 }
 
 func Contains[T comparable](list []T, item T) bool {
@@ -0,0 +1,131 @@
+package llm
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bincooo/zerobot-llm/emojis"
+	"github.com/bincooo/zerobot-llm/paragraph"
+	"github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"github.com/wdvxdr1123/ZeroBot/message"
+
+	zero "github.com/wdvxdr1123/ZeroBot"
+)
+
+// 普通模式的发送方式: final 生成结束后一次发送，progressive 每凑够一段就发送
+const (
+	deliveryFinal       = "final"
+	deliveryProgressive = "progressive"
+
+	chunkSize = 200 // progressive 每段最少字数
+
+	forwardLength = 1000 // 超过该字数以合并转发发送，0 关闭
+	forwardChunk  = 800  // 合并转发每个节点的字数上限
+)
+
+// 分段发送回复，第一段引用原消息
+type replier struct {
+	ctx  *zero.Ctx
+	sent bool
+}
+
+// 公式渲染成图片穿插在文本中
+func (r *replier) send(tex string) {
+	chain := latexChain(tex)
+	if !zero.OnlyPrivate(r.ctx) && !r.sent {
+		chain = append(message.Message{message.Reply(r.ctx.Event.MessageID)}, chain...)
+	}
+	r.ctx.SendChain(chain...)
+	r.sent = true
+}
+
+// 图片发送失败时返回 false，由调用方改发文本
+func (r *replier) sendImage(data []byte) bool {
+	var id message.MessageID
+	if zero.OnlyPrivate(r.ctx) || r.sent {
+		id = r.ctx.SendChain(message.ImageBytes(data))
+	} else {
+		id = r.ctx.SendChain(message.Reply(r.ctx.Event.MessageID), message.ImageBytes(data))
+	}
+	if id.ID() == 0 {
+		return false
+	}
+	r.sent = true
+	return true
+}
+
+// 长回复按段落拆成合并转发，发送失败时退回普通消息。需要时先渲染成图片，渲染 | 发送图片失败时按文本发送
+func (r *replier) sendLong(tex string) {
+	if shouldRender(tex) {
+		data, err := renderMarkdown(tex)
+		if err == nil && r.sendImage(data) {
+			return
+		}
+		if err != nil {
+			logrus.Warn("渲染回复失败: ", err)
+		} else {
+			logrus.Warn("发送图片失败，改为发送文本")
+		}
+	}
+
+	length := Db.OptionInt("forward_length", forwardLength)
+	if length <= 0 || utf8.RuneCountInString(tex) <= length {
+		r.send(tex)
+		return
+	}
+
+	nickname := "bot"
+	if len(zero.BotConfig.NickName) > 0 {
+		nickname = zero.BotConfig.NickName[0]
+	}
+
+	var nodes message.Message
+	for _, chunk := range paragraph.Split(tex, forwardChunk) {
+		nodes = append(nodes, message.CustomNode(nickname, r.ctx.Event.SelfID, latexChain(chunk)))
+	}
+
+	var result gjson.Result
+	if r.ctx.Event.GroupID > 0 {
+		result = r.ctx.SendGroupForwardMessage(r.ctx.Event.GroupID, nodes)
+	} else {
+		result = r.ctx.SendPrivateForwardMessage(r.ctx.Event.UserID, nodes)
+	}
+
+	if !result.Get("message_id").Exists() {
+		r.send(tex)
+		return
+	}
+	r.sent = true
+}
+
+// 边生成边按段落发送，返回完整内容和还没发送的部分
+func progressiveResponse(rp *replier, ch chan string, placeholder message.MessageID, min int, policy emojis.Policy) (result string, rest string, err error) {
+	f := policy.Filter()
+	for {
+		text, ok := <-ch
+		if !ok {
+			return policy.Apply(result), f.Apply(rest), nil
+		}
+
+		if strings.HasPrefix(text, "error: ") {
+			return "", "", errors.New(strings.TrimPrefix(text, "error: "))
+		}
+
+		text = strings.TrimPrefix(text, "text: ")
+		result += text
+		rest += text
+
+		head, tail, cut := paragraph.Cut(rest, min)
+		if !cut {
+			continue
+		}
+
+		if !rp.sent {
+			rp.ctx.DeleteMessage(placeholder)
+		}
+		rp.send(f.Apply(head))
+		rest = tail
+	}
+}
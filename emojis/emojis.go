@@ -0,0 +1,128 @@
+// Package emojis 按人设的表情策略处理回复中的 emoji
+package emojis
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/bincooo/go.emoji"
+)
+
+// 策略: keep 原样保留，strip 全部去掉，collapse 连续相同的只留一个，
+// cap 每条回复最多保留 N 个，whitelist 只保留列出的 emoji
+const (
+	Keep      = "keep"
+	Strip     = "strip"
+	Collapse  = "collapse"
+	Cap       = "cap"
+	Whitelist = "whitelist"
+)
+
+// Policy 表情策略
+type Policy struct {
+	Mode  string
+	Max   int      // cap 的上限
+	Allow []string // whitelist 允许的 emoji
+}
+
+// Parse 解析 "keep" "strip" "collapse" "cap 3" "whitelist 😀👍"
+func Parse(value string) (Policy, error) {
+	mode, arg, _ := strings.Cut(strings.TrimSpace(value), " ")
+	arg = strings.TrimSpace(arg)
+
+	switch mode {
+	case Keep, Strip, Collapse:
+		if arg != "" {
+			return Policy{}, errors.New(mode + " 不需要参数")
+		}
+		return Policy{Mode: mode}, nil
+	case Cap:
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 0 {
+			return Policy{}, errors.New("cap 需要一个非负整数: " + arg)
+		}
+		return Policy{Mode: Cap, Max: n}, nil
+	case Whitelist:
+		allow := Extract(arg)
+		if len(allow) == 0 {
+			return Policy{}, errors.New("whitelist 需要至少一个 emoji")
+		}
+		return Policy{Mode: Whitelist, Allow: allow}, nil
+	}
+	return Policy{}, errors.New("未知的表情策略: " + mode)
+}
+
+func (p Policy) String() string {
+	switch p.Mode {
+	case Cap:
+		return Cap + " " + strconv.Itoa(p.Max)
+	case Whitelist:
+		return Whitelist + " " + strings.Join(p.Allow, "")
+	}
+	return p.Mode
+}
+
+// Apply 处理一条完整的回复
+func (p Policy) Apply(text string) string {
+	return p.Filter().Apply(text)
+}
+
+// Filter 一条回复分多段发送时共用，cap 的计数跨段累计
+func (p Policy) Filter() *Filter {
+	return &Filter{policy: p}
+}
+
+// Filter 带计数的表情过滤器
+type Filter struct {
+	policy Policy
+	count  int
+}
+
+// Apply 处理一段完整的文本，不能是流式输出中途截断的内容，否则组合 emoji 会被拆开
+func (f *Filter) Apply(text string) string {
+	var (
+		end      = -1 // 上一个 emoji 的结束位置
+		previous string
+	)
+
+	return emoji.ReplaceEmoji(text, func(index int, e string) string {
+		repeated := index == end && e == previous
+		end, previous = index+len(e), e
+
+		switch f.policy.Mode {
+		case Strip:
+			return ""
+		case Collapse:
+			if repeated {
+				return ""
+			}
+		case Cap:
+			if f.count >= f.policy.Max {
+				return ""
+			}
+			f.count++
+		case Whitelist:
+			for _, allow := range f.policy.Allow {
+				if e == allow {
+					return e
+				}
+			}
+			return ""
+		}
+		return e
+	})
+}
+
+// Extract 取出文本中的所有 emoji，去重
+func Extract(text string) (list []string) {
+	seen := map[string]bool{}
+	emoji.ReplaceEmoji(text, func(_ int, e string) string {
+		if !seen[e] {
+			seen[e] = true
+			list = append(list, e)
+		}
+		return e
+	})
+	return
+}
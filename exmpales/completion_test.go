@@ -1,35 +1,69 @@
 package exmpales
 
 import (
-	"github.com/bincooo/go.emoji"
+	"strings"
 	"testing"
+
+	"github.com/bincooo/zerobot-llm/emojis"
 )
 
 func TestEmoji(t *testing.T) {
-	t.Log(cleanEmoji("hi🇨🇳🇨🇳🇨🇳US🇺🇸"))
+	t.Log(emojis.Policy{Mode: emojis.Collapse}.Apply("hi🇨🇳🇨🇳🇨🇳US🇺🇸"))
 }
 
-// 只保留一个emoji
-func cleanEmoji(raw string) string {
-	var (
-		pos      int
-		previous string
-	)
-
-	return emoji.ReplaceEmoji(raw, func(index int, emoji string) string {
-		if index-len(emoji) != pos {
-			previous = emoji
-			pos = index
-			return emoji
+func TestEmojiPolicy(t *testing.T) {
+	const raw = "好的😀😀😀 收到👍 🇨🇳🇨🇳 再见👋🏻👨‍👩‍👧"
+	for _, c := range []struct {
+		policy string
+		want   string
+	}{
+		{"keep", raw},
+		{"strip", "好的 收到  再见"},
+		{"collapse", "好的😀 收到👍 🇨🇳 再见👋🏻👨‍👩‍👧"},
+		{"cap 2", "好的😀😀 收到  再见"},
+		{"cap 0", "好的 收到  再见"},
+		{"whitelist 👍 👨‍👩‍👧", "好的 收到👍  再见👨‍👩‍👧"},
+	} {
+		p, err := emojis.Parse(c.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Apply(raw); got != c.want {
+			t.Errorf("%s: %q, want %q", c.policy, got, c.want)
 		}
+		if again, _ := emojis.Parse(p.String()); again.String() != p.String() {
+			t.Errorf("String() = %q", p.String())
+		}
+	}
+}
 
-		if emoji == previous {
-			pos = index
-			return ""
+func TestEmojiParse(t *testing.T) {
+	for _, value := range []string{"", "random", "cap", "cap -1", "cap x", "keep 1", "whitelist abc"} {
+		if _, err := emojis.Parse(value); err == nil {
+			t.Errorf("Parse(%q) should fail", value)
 		}
+	}
+}
+
+// 流式输出会把组合 emoji 拆到两个分片里，拼成完整输出后再处理
+func TestEmojiStream(t *testing.T) {
+	family := "👨‍👩‍👧"
+	chunks := []string{"一家人" + family[:len("👨‍")], family[len("👨‍"):] + family + "！"}
+
+	p := emojis.Policy{Mode: emojis.Collapse}
+	if got := p.Apply(strings.Join(chunks, "")); got != "一家人"+family+"！" {
+		t.Errorf("complete output: %q", got)
+	}
+}
 
-		previous = emoji
-		pos = index
-		return emoji
-	})
+// 分段发送时 cap 跨段计数
+func TestEmojiFilter(t *testing.T) {
+	f := emojis.Policy{Mode: emojis.Cap, Max: 2}.Filter()
+	var sent []string
+	for _, part := range []string{"第一段😀", "第二段😀😀", "第三段👍"} {
+		sent = append(sent, f.Apply(part))
+	}
+	if got := strings.Join(sent, "|"); got != "第一段😀|第二段😀|第三段" {
+		t.Errorf("filter: %q", got)
+	}
 }
@@ -0,0 +1,146 @@
+package llm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bincooo/emit.io"
+	"github.com/bincooo/zerobot-llm/export"
+	"github.com/bincooo/zerobot-llm/model"
+	zero "github.com/wdvxdr1123/ZeroBot"
+)
+
+// 最近一次上传的文件，用于 /import
+type upload struct {
+	name string
+	url  string
+}
+
+var (
+	uploads  = make(map[int64]upload)
+	uploadMu sync.Mutex
+)
+
+// 导出当前分支的全部对话
+func exportHistories(uid int64, name, format string) (string, error) {
+	histories, err := Db.FindHistory(uid, name, math.MaxInt32)
+	if err != nil {
+		if IsSqlNull(err) {
+			return "", errors.New("没有可以导出的对话记录")
+		}
+		return "", err
+	}
+
+	turns := make([]export.Turn, 0, len(histories))
+	for hL := len(histories) - 1; hL >= 0; hL-- {
+		h := histories[hL]
+		turns = append(turns, export.Turn{Timestamp: h.Timestamp, User: historyPrompt(h).plain(), Assistant: h.AssistantContent})
+	}
+
+	data, format, err := export.Format(format, name, uid, turns)
+	if err != nil {
+		return "", err
+	}
+
+	dir := engine.DataFolder() + "export/"
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	base := strings.NewReplacer("/", "_", "\\", "_").Replace(name)
+	file := fmt.Sprintf("%s%s-%d-%s.%s", dir, base, uid, time.Now().Format("20060102150405"), format)
+	if err = os.WriteFile(file, data, 0644); err != nil {
+		return "", err
+	}
+	return filepath.Abs(file)
+}
+
+// 导入到当前分支末端
+func importHistories(uid int64, name string, turns []export.Turn) (int, error) {
+	count := 0
+	for _, turn := range turns {
+		if turn.User == "" || turn.Assistant == "" {
+			continue
+		}
+
+		err := Db.SaveHistory(model.History{
+			Timestamp:        turn.Timestamp,
+			Uid:              uid,
+			Name:             name,
+			UserContent:      turn.User,
+			AssistantContent: turn.Assistant,
+			Format:           model.FormatText,
+		})
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// 导入文件的大小上限
+const importMaxSize = 16 << 20
+
+func download(url string) ([]byte, error) {
+	response, err := emit.ClientBuilder().
+		GET(url).
+		DoC(emit.Status(http.StatusOK))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	tooLarge := fmt.Errorf("文件超过 %s，无法导入", formatBytes(importMaxSize))
+	if response.ContentLength > importMaxSize {
+		return nil, tooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(response.Body, importMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > importMaxSize {
+		return nil, tooLarge
+	}
+	return data, nil
+}
+
+// 记录上传的文件
+func rememberUpload(ctx *zero.Ctx) {
+	file := ctx.Event.File
+	if file == nil {
+		return
+	}
+
+	url := ctx.Event.RawEvent.Get("file.url").String()
+	if url == "" && ctx.Event.GroupID > 0 {
+		url = ctx.GetThisGroupFileUrl(file.BusID, file.ID)
+	}
+	if url == "" {
+		return
+	}
+
+	uid := ctx.Event.UserID
+	if ctx.Event.GroupID > 0 {
+		uid = ctx.Event.GroupID
+	}
+
+	uploadMu.Lock()
+	defer uploadMu.Unlock()
+	uploads[uid] = upload{file.Name, url}
+}
+
+func lastUpload(uid int64) (upload, bool) {
+	uploadMu.Lock()
+	defer uploadMu.Unlock()
+	u, ok := uploads[uid]
+	return u, ok
+}
@@ -0,0 +1,181 @@
+// Package export 对话记录的导出 | 导入格式: json、md、jsonl
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Formats 支持的导出格式
+var Formats = []string{"json", "md", "jsonl"}
+
+// File json 格式的导出文件
+type File struct {
+	Version   int    `json:"version"`
+	Key       string `json:"key"`
+	Uid       int64  `json:"uid"`
+	Histories []Turn `json:"histories"`
+}
+
+// Turn 一轮对话
+type Turn struct {
+	Timestamp int64  `json:"timestamp"`
+	User      string `json:"user"`
+	Assistant string `json:"assistant"`
+}
+
+// Format 按格式生成文件内容，未知格式按 json 处理，返回实际使用的格式
+func Format(format, name string, uid int64, turns []Turn) ([]byte, string, error) {
+	switch format {
+	case "md":
+		return Markdown(name, turns), format, nil
+	case "jsonl":
+		data, err := Jsonl(turns)
+		return data, format, err
+	default:
+		data, err := json.MarshalIndent(File{1, name, uid, turns}, "", "  ")
+		return data, "json", err
+	}
+}
+
+// Markdown 每轮对话以 "## 时间" 开头
+func Markdown(name string, turns []Turn) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("# " + name + "\n")
+	for _, turn := range turns {
+		buf.WriteString("\n## " + time.Unix(turn.Timestamp, 0).Format(time.RFC3339) + "\n")
+		buf.WriteString("\n### user\n\n" + strings.TrimSpace(turn.User) + "\n")
+		buf.WriteString("\n### assistant\n\n" + strings.TrimSpace(turn.Assistant) + "\n")
+	}
+	return buf.Bytes()
+}
+
+// Jsonl OpenAI chat 微调格式，整段对话作为一条样本。
+// timestamps 按顺序对应每轮的时间，导入时用于保留原来的时间
+func Jsonl(turns []Turn) ([]byte, error) {
+	messages := make([]map[string]string, 0, 2*len(turns))
+	timestamps := make([]int64, 0, len(turns))
+	for _, turn := range turns {
+		messages = append(messages,
+			map[string]string{"role": "user", "content": turn.User},
+			map[string]string{"role": "assistant", "content": turn.Assistant})
+		timestamps = append(timestamps, turn.Timestamp)
+	}
+
+	data, err := json.Marshal(map[string]interface{}{"messages": messages, "timestamps": timestamps})
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Parse 按文件扩展名解析
+func Parse(filename string, data []byte) ([]Turn, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		var file File
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, err
+		}
+		return file.Histories, nil
+	case ".md":
+		return ParseMarkdown(data)
+	case ".jsonl":
+		return ParseJsonl(data)
+	default:
+		return nil, errors.New("不支持的文件格式: " + filename)
+	}
+}
+
+// ParseMarkdown 解析 Markdown 导出的文件
+func ParseMarkdown(data []byte) ([]Turn, error) {
+	var (
+		turns   []Turn
+		current *Turn
+		section *string
+		lines   []string
+	)
+
+	flush := func() {
+		if section != nil {
+			*section = strings.TrimSpace(strings.Join(lines, "\n"))
+		}
+		section, lines = nil, nil
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		// 每轮对话以 "## 时间" 开头，避免与正文中的标题混淆
+		t, err := time.Parse(time.RFC3339, strings.TrimSpace(strings.TrimPrefix(line, "## ")))
+		switch {
+		case strings.HasPrefix(line, "## ") && err == nil:
+			flush()
+			turns = append(turns, Turn{Timestamp: t.Unix()})
+			current = &turns[len(turns)-1]
+		case current != nil && strings.TrimSpace(line) == "### user":
+			flush()
+			section = &current.User
+		case current != nil && strings.TrimSpace(line) == "### assistant":
+			flush()
+			section = &current.Assistant
+		default:
+			lines = append(lines, line)
+		}
+	}
+	flush()
+
+	if len(turns) == 0 {
+		return nil, errors.New("没有解析到对话记录")
+	}
+	return turns, nil
+}
+
+// ParseJsonl 解析微调格式，忽略 system 消息，没有回复的 user 消息 Assistant 为空。
+// 没有 timestamps 的样本 (其它工具生成的) 使用导入时的时间
+func ParseJsonl(data []byte) ([]Turn, error) {
+	var turns []Turn
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	now := time.Now().Unix()
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var sample struct {
+			Messages   []map[string]string `json:"messages"`
+			Timestamps []int64             `json:"timestamps"`
+		}
+		if err := json.Unmarshal(line, &sample); err != nil {
+			return nil, err
+		}
+
+		var (
+			turn  *Turn
+			index int
+		)
+		for _, msg := range sample.Messages {
+			switch msg["role"] {
+			case "user":
+				timestamp := now
+				if index < len(sample.Timestamps) && sample.Timestamps[index] > 0 {
+					timestamp = sample.Timestamps[index]
+				}
+				index++
+				turns = append(turns, Turn{Timestamp: timestamp, User: msg["content"]})
+				turn = &turns[len(turns)-1]
+			case "assistant":
+				if turn != nil {
+					turn.Assistant = msg["content"]
+					turn = nil
+				}
+			}
+		}
+	}
+	return turns, scanner.Err()
+}
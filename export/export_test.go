@@ -0,0 +1,80 @@
+package export
+
+import (
+	"slices"
+	"testing"
+)
+
+var turns = []Turn{
+	{Timestamp: 1700000000, User: "你好", Assistant: "你好！"},
+	// 回答里的标题不能被当成新的一轮
+	{Timestamp: 1700000060, User: "总结一下", Assistant: "## 小结\n\n第一点\n\n### 细节\n\n- a\n- b"},
+	{Timestamp: 1700000120, User: "代码\n```go\nfmt.Println(1)\n```", Assistant: "好的"},
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range Formats {
+		data, used, err := Format(format, "gpt", 1, turns)
+		if err != nil || used != format {
+			t.Fatalf("Format(%s) = %s, %v", format, used, err)
+		}
+
+		parsed, err := Parse("export."+format, data)
+		if err != nil {
+			t.Fatalf("Parse(%s): %v", format, err)
+		}
+		if len(parsed) != len(turns) {
+			t.Fatalf("%s: %d turns, want %d\n%s", format, len(parsed), len(turns), data)
+		}
+		for i, turn := range parsed {
+			if turn != turns[i] {
+				t.Errorf("%s turn %d = %+v, want %+v", format, i, turn, turns[i])
+			}
+		}
+	}
+}
+
+func TestParseJsonl(t *testing.T) {
+	data := []byte(`{"messages":[{"role":"system","content":"你是助手"},{"role":"user","content":"a"},{"role":"assistant","content":"b"}]}
+
+{"messages":[{"role":"system","content":"另一条样本"},{"role":"user","content":"c"},{"role":"assistant","content":"d"},{"role":"user","content":"e"}]}
+`)
+	parsed, err := ParseJsonl(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, turn := range parsed {
+		got = append(got, turn.User+"="+turn.Assistant)
+		if turn.Timestamp == 0 {
+			t.Errorf("timestamp of %q is 0", turn.User)
+		}
+	}
+	// system 消息忽略，最后没有回复的 user 保留为空回答，导入时跳过
+	if want := []string{"a=b", "c=d", "e="}; !slices.Equal(got, want) {
+		t.Fatalf("ParseJsonl = %q", got)
+	}
+
+	// timestamps 与 user 消息按顺序对应，缺少的使用导入时间
+	data = []byte(`{"messages":[{"role":"user","content":"a"},{"role":"assistant","content":"b"},{"role":"user","content":"c"}],"timestamps":[100]}`)
+	if parsed, err = ParseJsonl(data); err != nil || len(parsed) != 2 || parsed[0].Timestamp != 100 || parsed[1].Timestamp <= 100 {
+		t.Fatalf("ParseJsonl timestamps = %+v, %v", parsed, err)
+	}
+
+	if _, err = ParseJsonl([]byte("{")); err == nil {
+		t.Error("ParseJsonl should fail on invalid json")
+	}
+}
+
+func TestParse(t *testing.T) {
+	if _, err := Parse("a.txt", nil); err == nil {
+		t.Error("Parse(a.txt) should fail")
+	}
+	if _, err := ParseMarkdown([]byte("# gpt\n\n## 小结\n\n没有时间")); err == nil {
+		t.Error("ParseMarkdown without turns should fail")
+	}
+	if _, used, _ := Format("csv", "gpt", 1, turns); used != "json" {
+		t.Errorf("Format(csv) = %s", used)
+	}
+}
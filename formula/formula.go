@@ -0,0 +1,134 @@
+// Package formula 从回复中找出 LaTeX 公式，$...$ \(...\) 为行内，$$...$$ \[...\] 为独立公式
+package formula
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Segment 文本片段，Math 为 true 时 Text 是去掉定界符的公式
+type Segment struct {
+	Text    string
+	Math    bool
+	Display bool
+}
+
+// 定界符按顺序匹配，$$ 要在 $ 之前
+var delimiters = []struct {
+	open, close string
+	display     bool
+}{
+	{"$$", "$$", true},
+	{`\[`, `\]`, true},
+	{`\(`, `\)`, false},
+	{"$", "$", false},
+}
+
+// Split 切成文本和公式交替的片段，代码块 | 行内代码里的内容不处理
+func Split(text string) []Segment {
+	var (
+		segments []Segment
+		last     int
+	)
+
+	push := func(seg Segment) {
+		if seg.Text == "" {
+			return
+		}
+		if n := len(segments); n > 0 && !seg.Math && !segments[n-1].Math {
+			segments[n-1].Text += seg.Text
+			return
+		}
+		segments = append(segments, seg)
+	}
+
+	for i := 0; i < len(text); {
+		switch {
+		case strings.HasPrefix(text[i:], "```"):
+			i = skip(text, i, "```")
+			continue
+		case text[i] == '`':
+			i = skip(text, i, "`")
+			continue
+		case text[i] == '\\' && i+1 < len(text) && text[i+1] == '$':
+			i += 2
+			continue
+		}
+
+		start, end, expr, display, ok := match(text, i)
+		if !ok {
+			i++
+			continue
+		}
+
+		push(Segment{Text: text[last:start]})
+		push(Segment{Text: expr, Math: true, Display: display})
+		last, i = end, end
+	}
+
+	push(Segment{Text: text[last:]})
+	return segments
+}
+
+// Has 是否包含公式
+func Has(text string) bool {
+	for _, seg := range Split(text) {
+		if seg.Math {
+			return true
+		}
+	}
+	return false
+}
+
+// 跳过成对的 ` 或 ```，没有闭合时跳到末尾
+func skip(text string, i int, fence string) int {
+	end := strings.Index(text[i+len(fence):], fence)
+	if end < 0 {
+		return len(text)
+	}
+	return i + len(fence) + end + len(fence)
+}
+
+// 在 i 处尝试匹配一个公式
+func match(text string, i int) (start, end int, expr string, display, ok bool) {
+	for _, d := range delimiters {
+		if !strings.HasPrefix(text[i:], d.open) {
+			continue
+		}
+
+		body := text[i+len(d.open):]
+		n := strings.Index(body, d.close)
+		if n <= 0 {
+			return
+		}
+		expr = body[:n]
+		end = i + len(d.open) + n + len(d.close)
+
+		// 单个 $ 容易和金额混淆: 不能跨行，内侧不能是空白，闭合后不能紧跟数字
+		if d.open == "$" && !inlineDollar(expr, text[end:]) {
+			return
+		}
+
+		if expr = strings.TrimSpace(expr); expr == "" {
+			return
+		}
+		return i, end, expr, d.display, true
+	}
+	return
+}
+
+func inlineDollar(expr, after string) bool {
+	if strings.Contains(expr, "\n") {
+		return false
+	}
+
+	first, _ := utf8.DecodeRuneInString(expr)
+	lastRune, _ := utf8.DecodeLastRuneInString(expr)
+	if unicode.IsSpace(first) || unicode.IsSpace(lastRune) {
+		return false
+	}
+
+	next, _ := utf8.DecodeRuneInString(after)
+	return !unicode.IsDigit(next)
+}
@@ -0,0 +1,67 @@
+package formula
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	for _, c := range []struct {
+		text string
+		want []Segment
+	}{
+		{"没有公式", []Segment{{Text: "没有公式"}}},
+		{"勾股定理 $a^2+b^2=c^2$ 成立", []Segment{
+			{Text: "勾股定理 "},
+			{Text: "a^2+b^2=c^2", Math: true},
+			{Text: " 成立"},
+		}},
+		{"结果:\n$$\n\\frac{1}{2}\n$$\n完", []Segment{
+			{Text: "结果:\n"},
+			{Text: `\frac{1}{2}`, Math: true, Display: true},
+			{Text: "\n完"},
+		}},
+		{`\[x=1\]和\(y\)`, []Segment{
+			{Text: "x=1", Math: true, Display: true},
+			{Text: "和"},
+			{Text: "y", Math: true},
+		}},
+		// 金额不是公式
+		{"价格 $5 到 $10 之间", []Segment{{Text: "价格 $5 到 $10 之间"}}},
+		{"花了 $ 5 $ 块", []Segment{{Text: "花了 $ 5 $ 块"}}},
+		{`转义 \$x$ 不算`, []Segment{{Text: `转义 \$x$ 不算`}}},
+		// 代码里的不处理
+		{"`$x$` 和\n```\n$y$\n```", []Segment{{Text: "`$x$` 和\n```\n$y$\n```"}}},
+		{"$$ $$", []Segment{{Text: "$$ $$"}}},
+	} {
+		got := Split(c.text)
+		if !slices.Equal(got, c.want) {
+			t.Errorf("Split(%q) = %+v", c.text, got)
+		}
+	}
+}
+
+func TestHas(t *testing.T) {
+	if !Has("$x$") || Has("$5 and $10") || Has("") {
+		t.Error("Has")
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	for _, c := range []struct{ expr, want string }{
+		{"a^2+b^2=c^2", "a²+b²=c²"},
+		{`x_{n+1} = x_n - \frac{f(x_n)}{f'(x_n)}`, "xₙ₊₁ = xₙ - (f(xₙ))/(f'(xₙ))"},
+		{`\frac{-b \pm \sqrt{b^2-4ac}}{2a}`, "(-b ± √(b²-4ac))/(2a)"},
+		{`\sum_{i=1}^{n} i = \frac{n(n+1)}{2}`, "∑ᵢ₌₁ⁿ i = (n(n+1))/2"},
+		{`\int_0^\infty e^{-x^2} dx`, "∫₀^∞ e^(-x²) dx"},
+		{`\alpha \leq \beta \cdot \gamma`, "α ≤ β · γ"},
+		{`\sin\theta + \sqrt[3]{8}`, "sin θ + ∛8"},
+		{`\left( \text{if } x \right)`, "( if x )"},
+		{`\begin{aligned} a &= 1 \\ b &= 2 \end{aligned}`, "a = 1\nb = 2"},
+		{`\unknown{x}`, `\unknown x`},
+	} {
+		if got := Unicode(c.expr); got != c.want {
+			t.Errorf("Unicode(%q) = %q, want %q", c.expr, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package formula
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+var symbols = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ε", "varepsilon": "ε",
+	"zeta": "ζ", "eta": "η", "theta": "θ", "vartheta": "ϑ", "iota": "ι", "kappa": "κ",
+	"lambda": "λ", "mu": "μ", "nu": "ν", "xi": "ξ", "pi": "π", "rho": "ρ", "sigma": "σ",
+	"tau": "τ", "upsilon": "υ", "phi": "φ", "varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π",
+	"Sigma": "Σ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+
+	"times": "×", "cdot": "·", "div": "÷", "pm": "±", "mp": "∓", "ast": "∗", "circ": "∘",
+	"le": "≤", "leq": "≤", "ge": "≥", "geq": "≥", "neq": "≠", "ne": "≠", "approx": "≈",
+	"equiv": "≡", "sim": "∼", "simeq": "≃", "propto": "∝", "ll": "≪", "gg": "≫",
+	"infty": "∞", "partial": "∂", "nabla": "∇", "hbar": "ℏ", "ell": "ℓ", "emptyset": "∅",
+	"sum": "∑", "prod": "∏", "int": "∫", "iint": "∬", "oint": "∮",
+	"in": "∈", "notin": "∉", "ni": "∋", "subset": "⊂", "subseteq": "⊆", "supset": "⊃",
+	"supseteq": "⊇", "cup": "∪", "cap": "∩", "forall": "∀", "exists": "∃", "neg": "¬",
+	"land": "∧", "wedge": "∧", "lor": "∨", "vee": "∨", "oplus": "⊕", "otimes": "⊗",
+	"to": "→", "rightarrow": "→", "leftarrow": "←", "Rightarrow": "⇒", "Leftarrow": "⇐",
+	"leftrightarrow": "↔", "Leftrightarrow": "⇔", "iff": "⇔", "implies": "⇒", "mapsto": "↦",
+	"ldots": "…", "cdots": "⋯", "dots": "…", "vdots": "⋮", "angle": "∠", "perp": "⊥",
+	"parallel": "∥", "degree": "°", "prime": "′", "lfloor": "⌊", "rfloor": "⌋",
+	"lceil": "⌈", "rceil": "⌉", "langle": "⟨", "rangle": "⟩", "mid": "|",
+	"{": "{", "}": "}", "%": "%", "$": "$", "#": "#", "&": "&", "_": "_", "|": "‖",
+	",": " ", ";": " ", ":": " ", "!": "", " ": " ", "quad": "  ", "qquad": "    ",
+	"sin": "sin", "cos": "cos", "tan": "tan", "cot": "cot", "sec": "sec", "csc": "csc",
+	"arcsin": "arcsin", "arccos": "arccos", "arctan": "arctan", "sinh": "sinh", "cosh": "cosh",
+	"tanh": "tanh", "log": "log", "ln": "ln", "lg": "lg", "exp": "exp", "lim": "lim",
+	"max": "max", "min": "min", "sup": "sup", "inf": "inf", "det": "det", "gcd": "gcd",
+	"mod": "mod", "bmod": "mod",
+}
+
+var (
+	superscripts = map[rune]rune{
+		'0': '⁰', '1': '¹', '2': '²', '3': '³', '4': '⁴', '5': '⁵', '6': '⁶', '7': '⁷', '8': '⁸', '9': '⁹',
+		'+': '⁺', '-': '⁻', '=': '⁼', '(': '⁽', ')': '⁾', 'n': 'ⁿ', 'i': 'ⁱ', 'x': 'ˣ', 'y': 'ʸ',
+		'a': 'ᵃ', 'b': 'ᵇ', 'c': 'ᶜ', 'd': 'ᵈ', 'e': 'ᵉ', 'k': 'ᵏ', 'm': 'ᵐ', 't': 'ᵗ', 'T': 'ᵀ',
+		'′': '′', '*': '*',
+	}
+	subscripts = map[rune]rune{
+		'0': '₀', '1': '₁', '2': '₂', '3': '₃', '4': '₄', '5': '₅', '6': '₆', '7': '₇', '8': '₈', '9': '₉',
+		'+': '₊', '-': '₋', '=': '₌', '(': '₍', ')': '₎', 'a': 'ₐ', 'e': 'ₑ', 'i': 'ᵢ', 'j': 'ⱼ',
+		'k': 'ₖ', 'n': 'ₙ', 'm': 'ₘ', 'o': 'ₒ', 'p': 'ₚ', 'r': 'ᵣ', 's': 'ₛ', 't': 'ₜ', 'x': 'ₓ',
+	}
+)
+
+// 只取参数内容的命令
+var passthrough = map[string]bool{
+	"text": true, "mathrm": true, "mathbf": true, "mathit": true, "mathsf": true, "mathtt": true,
+	"operatorname": true, "textbf": true, "textit": true, "boldsymbol": true,
+}
+
+// Unicode 把公式转换成近似的 unicode 文本，上下标 | 分式 | 根号尽量用单个字符表示
+func Unicode(expr string) string {
+	p := parser{src: expr}
+
+	// 多行公式 (\\ 换行) 保留换行，行内多余空白合并
+	var lines []string
+	for _, line := range strings.Split(p.parse(false), "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+type parser struct {
+	src string
+	pos int
+}
+
+func (p *parser) eof() bool { return p.pos >= len(p.src) }
+
+// 解析到末尾，group 为 true 时遇到 } 结束
+func (p *parser) parse(group bool) string {
+	var sb strings.Builder
+	for !p.eof() {
+		c := p.src[p.pos]
+		switch c {
+		case '}':
+			p.pos++
+			if group {
+				return sb.String()
+			}
+		case '{':
+			p.pos++
+			sb.WriteString(p.parse(true))
+		case '^', '_':
+			p.pos++
+			sb.WriteString(script(p.arg(), c == '^'))
+		case '\\':
+			sb.WriteString(p.command())
+		case '&':
+			p.pos++
+			sb.WriteString(" ")
+		case '~':
+			p.pos++
+			sb.WriteString(" ")
+		default:
+			r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+			p.pos += size
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// 读取一个参数: {...}、命令或单个字符
+func (p *parser) arg() string {
+	for !p.eof() && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+	if p.eof() {
+		return ""
+	}
+
+	switch p.src[p.pos] {
+	case '{':
+		p.pos++
+		return p.parse(true)
+	case '\\':
+		return p.command()
+	}
+	r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+	p.pos += size
+	return string(r)
+}
+
+func (p *parser) command() string {
+	p.pos++ // 跳过 \
+	if p.eof() {
+		return ""
+	}
+
+	start := p.pos
+	for !p.eof() && isLetter(p.src[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		p.pos++ // \\ \, \{ 这类单字符命令
+	}
+	name := p.src[start:p.pos]
+
+	switch name {
+	case "\\":
+		return "\n"
+	case "frac", "dfrac", "tfrac":
+		num, den := p.arg(), p.arg()
+		return wrap(num) + "/" + wrap(den)
+	case "sqrt":
+		index := ""
+		if !p.eof() && p.src[p.pos] == '[' {
+			if end := strings.IndexByte(p.src[p.pos:], ']'); end > 0 {
+				index = p.src[p.pos+1 : p.pos+end]
+				p.pos += end + 1
+			}
+		}
+		root := "√"
+		switch index {
+		case "3":
+			root = "∛"
+		case "4":
+			root = "∜"
+		case "":
+		default:
+			root = script(index, true) + "√"
+		}
+		return root + wrap(p.arg())
+	case "left", "right", "big", "Big", "bigg", "Bigg", "displaystyle", "limits":
+		if name == "left" || name == "right" {
+			if arg := p.arg(); arg != "." {
+				return arg
+			}
+		}
+		return ""
+	case "begin", "end":
+		p.arg()
+		return ""
+	case "overline", "bar":
+		return combine(p.arg(), '̅')
+	case "vec":
+		return combine(p.arg(), '⃗')
+	case "hat":
+		return combine(p.arg(), '̂')
+	case "dot":
+		return combine(p.arg(), '̇')
+	case "tilde":
+		return combine(p.arg(), '̃')
+	}
+
+	if passthrough[name] {
+		return p.arg()
+	}
+	if s, ok := symbols[name]; ok {
+		if isLetter(name[0]) && len(s) > 1 && s == name {
+			// sin cos 这类函数名后面留空格
+			return s + " "
+		}
+		return s
+	}
+	return "\\" + name + " "
+}
+
+// 上下标能全部转换时用 unicode 字符，否则写成 ^(...) _(...)
+func script(s string, sup bool) string {
+	table, mark := subscripts, "_"
+	if sup {
+		table, mark = superscripts, "^"
+	}
+
+	var sb strings.Builder
+	for _, r := range s {
+		c, ok := table[r]
+		if !ok {
+			return mark + wrap(s)
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+// 多个字符时加括号
+func wrap(s string) string {
+	s = strings.TrimSpace(s)
+	if utf8.RuneCountInString(s) <= 1 || strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		return s
+	}
+	return "(" + s + ")"
+}
+
+// 每个字符后面加组合符号
+func combine(s string, mark rune) string {
+	var sb strings.Builder
+	for _, r := range s {
+		sb.WriteRune(r)
+		sb.WriteRune(mark)
+	}
+	return sb.String()
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -4,19 +4,23 @@ go 1.21.6
 
 require (
 	github.com/FloatTech/floatbox v0.0.0-20230331064925-9af336a84944
+	github.com/FloatTech/gg v1.1.2
+	github.com/FloatTech/rendercard v0.0.10-0.20230223064326-45d29fa4ede9
 	github.com/FloatTech/sqlite v1.6.2
 	github.com/FloatTech/zbpctrl v1.5.3-0.20230514154630-b74e6fcca380
 	github.com/FloatTech/zbputils v1.7.0
 	github.com/bincooo/emit.io v0.0.0-20240530174536-ed3f9ef9eaa9
 	github.com/bincooo/go.emoji v0.0.0-20240602073103-14053206aeb1
+	github.com/go-fonts/dejavu v0.1.0
+	github.com/go-latex/latex v0.0.0-20230307184459-12ec69307ad9
 	github.com/sirupsen/logrus v1.9.0
+	github.com/tidwall/gjson v1.17.1
 	github.com/wdvxdr1123/ZeroBot v1.7.4
+	golang.org/x/image v0.16.0
 )
 
 require (
-	github.com/FloatTech/gg v1.1.2 // indirect
 	github.com/FloatTech/imgfactory v0.2.2-0.20230315152233-49741fc994f9 // indirect
-	github.com/FloatTech/rendercard v0.0.10-0.20230223064326-45d29fa4ede9 // indirect
 	github.com/FloatTech/ttl v0.0.0-20220715042055-15612be72f5b // indirect
 	github.com/PuerkitoBio/goquery v1.9.2 // indirect
 	github.com/RomiChan/syncx v0.0.0-20221202055724-5f842c53020e // indirect
@@ -62,7 +66,6 @@ require (
 	github.com/pbnjay/memory v0.0.0-20210728143218-7b4eea64cf58 // indirect
 	github.com/refraction-networking/utls v1.6.6 // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20200410134404-eec4a21b6bb0 // indirect
-	github.com/tidwall/gjson v1.17.1 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.1 // indirect
 	github.com/zeebo/blake3 v0.2.3 // indirect
@@ -71,7 +74,6 @@ require (
 	go.uber.org/zap v1.27.0 // indirect
 	golang.org/x/crypto v0.23.0 // indirect
 	golang.org/x/exp v0.0.0-20240525044651-4c93da0ed11d // indirect
-	golang.org/x/image v0.16.0 // indirect
 	golang.org/x/mod v0.17.0 // indirect
 	golang.org/x/net v0.25.0 // indirect
 	golang.org/x/sync v0.7.0 // indirect
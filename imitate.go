@@ -0,0 +1,148 @@
+package llm
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bincooo/zerobot-llm/model"
+	"github.com/sirupsen/logrus"
+	"github.com/wdvxdr1123/ZeroBot/message"
+
+	zero "github.com/wdvxdr1123/ZeroBot"
+)
+
+// 自由发言的触发信号，取值 0~1，乘以权重后加到基础频率 Freq 上
+var (
+	scoreSignals = []string{"mention", "question", "burst", "idle", "reply"}
+
+	defaultWeights = map[string]int{
+		"mention":  60, // 提到机器人昵称 | 关键词
+		"question": 15, // 疑问句
+		"burst":    10, // 一分钟内消息密集
+		"idle":     10, // 距离机器人上次发言越久越高，30 分钟封顶
+		"reply":    80, // 回复机器人的消息
+	}
+
+	questionWords = []string{"?", "？", "吗", "呢", "什么", "怎么", "为什么", "为啥", "谁", "哪"}
+)
+
+const (
+	burstWindow = time.Minute
+	burstCount  = 6
+	idleWindow  = 30 * time.Minute
+)
+
+var (
+	lastSpoke   = make(map[int64]time.Time)
+	lastSpokeMu sync.Mutex
+)
+
+// 记录机器人在聊天室的发言时间
+func markSpoke(uid int64) {
+	lastSpokeMu.Lock()
+	defer lastSpokeMu.Unlock()
+	lastSpoke[uid] = time.Now()
+}
+
+func sinceSpoke(uid int64) time.Duration {
+	lastSpokeMu.Lock()
+	defer lastSpokeMu.Unlock()
+	t, ok := lastSpoke[uid]
+	if !ok {
+		return idleWindow
+	}
+	return time.Since(t)
+}
+
+// 群单独设置 weight.<signal>.<gid>，其次全局 weight.<signal>
+func scoreWeight(gid int64, signal string) int {
+	def := Db.OptionInt("weight."+signal, defaultWeights[signal])
+	if gid == 0 {
+		return def
+	}
+	return Db.OptionInt("weight."+signal+"."+strconv.FormatInt(gid, 10), def)
+}
+
+func scoreKeywords(gid int64) []string {
+	value := Db.Option("keywords", "")
+	if gid != 0 {
+		value = Db.Option("keywords."+strconv.FormatInt(gid, 10), value)
+	}
+
+	keywords := append([]string{}, zero.BotConfig.NickName...)
+	for _, keyword := range strings.Split(value, ",") {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			keywords = append(keywords, keyword)
+		}
+	}
+	return keywords
+}
+
+// 计算本条消息触发自由发言的概率 0~100，recent 为最近一分钟内的消息数
+func triggerScore(ctx *zero.Ctx, c model.Config, uid int64, content string, recent int) int {
+	gid := ctx.Event.GroupID
+	if coolingDown(uid) {
+		return 0
+	}
+
+	scale := scheduleScale(gid, time.Now())
+	if scale <= 0 {
+		return 0
+	}
+
+	signals := map[string]float64{}
+
+	for _, keyword := range scoreKeywords(gid) {
+		if strings.Contains(content, keyword) {
+			signals["mention"] = 1
+			break
+		}
+	}
+
+	for _, word := range questionWords {
+		if strings.Contains(content, word) {
+			signals["question"] = 1
+			break
+		}
+	}
+
+	signals["burst"] = math.Min(1, float64(recent)/burstCount)
+	signals["idle"] = math.Min(1, float64(sinceSpoke(uid))/float64(idleWindow))
+
+	if isReplyToBot(ctx) {
+		signals["reply"] = 1
+	}
+
+	score := float64(c.Freq)
+	for _, signal := range scoreSignals {
+		score += signals[signal] * float64(scoreWeight(gid, signal))
+	}
+
+	score = score * float64(scale) / 100
+	logrus.Debugf("自由发言评分 [%d]: %.1f %v", uid, score, signals)
+	return int(math.Max(0, math.Min(100, score)))
+}
+
+func isReplyToBot(ctx *zero.Ctx) bool {
+	for _, seg := range ctx.Event.Message {
+		if seg.Type != "reply" {
+			continue
+		}
+		msg := ctx.GetMessage(message.NewMessageIDFromString(seg.Data["id"]))
+		return msg.Sender != nil && msg.Sender.ID == ctx.Event.SelfID
+	}
+	return false
+}
+
+// 当前生效的权重
+func formatWeights(gid int64) string {
+	var content []string
+	for _, signal := range scoreSignals {
+		content = append(content, fmt.Sprintf("%s=%d", signal, scoreWeight(gid, signal)))
+	}
+	return strings.Join(content, " ")
+}
@@ -0,0 +1,60 @@
+package llm
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	janitorOnce sync.Once
+
+	// 清理间隔
+	janitorInterval = time.Hour
+	// 默认每天 VACUUM 一次
+	vacuumHours = 24
+)
+
+func startJanitor() {
+	janitorOnce.Do(func() {
+		go func() {
+			for {
+				janitor()
+				time.Sleep(janitorInterval)
+			}
+		}()
+	})
+}
+
+// 按保留策略清理对话记录，并定期 VACUUM
+func janitor() {
+	var before int64
+	if days := Db.OptionInt("retention_days", 0); days > 0 {
+		before = time.Now().AddDate(0, 0, -days).Unix()
+	}
+
+	count, err := Db.PruneHistories(before, Db.OptionInt("retention_rows", 0))
+	if err != nil {
+		logrus.Error("清理对话记录失败: ", err)
+	} else if count > 0 {
+		logrus.Infof("已清理 %d 条过期对话记录", count)
+	}
+
+	hours := Db.OptionInt("vacuum_hours", vacuumHours)
+	if hours <= 0 {
+		return
+	}
+
+	last, _ := strconv.ParseInt(Db.Option("vacuum_last", "0"), 10, 64)
+	if time.Since(time.Unix(last, 0)) < time.Duration(hours)*time.Hour {
+		return
+	}
+
+	if err = Db.Vacuum(); err != nil {
+		logrus.Error("VACUUM 失败: ", err)
+		return
+	}
+	_ = Db.SetOption("vacuum_last", strconv.FormatInt(time.Now().Unix(), 10))
+}
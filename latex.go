@@ -0,0 +1,154 @@
+package llm
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"math"
+	"strings"
+
+	"github.com/FloatTech/gg"
+	"github.com/bincooo/zerobot-llm/formula"
+	"github.com/go-fonts/dejavu/dejavusans"
+	"github.com/go-latex/latex/drawtex"
+	"github.com/go-latex/latex/mtex"
+	"github.com/sirupsen/logrus"
+	"github.com/wdvxdr1123/ZeroBot/message"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+)
+
+const (
+	latexSize    = 20.0 // 公式字号
+	latexDisplay = 26.0 // 独立公式字号
+	latexDpi     = 144.0
+	latexPadding = 8
+)
+
+// 把 mtex 的绘制指令画到白底图片上
+type latexCanvas struct {
+	buf bytes.Buffer
+}
+
+func (c *latexCanvas) Render(width, height, dpi float64, cnv *drawtex.Canvas) error {
+	scale := dpi / 72
+	dc := gg.NewContext(int(math.Ceil(width*dpi))+2*latexPadding, int(math.Ceil(height*dpi))+2*latexPadding)
+	dc.SetColor(color.White)
+	dc.Clear()
+	dc.SetColor(color.Black)
+	dc.Translate(latexPadding, latexPadding)
+
+	for _, op := range cnv.Ops() {
+		switch op := op.(type) {
+		case drawtex.GlyphOp:
+			face, err := opentype.NewFace(op.Glyph.Font, &opentype.FaceOptions{
+				Size:    op.Glyph.Size,
+				DPI:     dpi,
+				Hinting: font.HintingNone,
+			})
+			if err != nil {
+				return err
+			}
+			dc.SetFontFace(face)
+			dc.DrawString(op.Glyph.Symbol, op.X*scale, op.Y*scale)
+			_ = face.Close()
+		case drawtex.RectOp:
+			dc.DrawRectangle(op.X1*scale, op.Y1*scale, (op.X2-op.X1)*scale, (op.Y2-op.Y1)*scale)
+			dc.Fill()
+		}
+	}
+	return dc.EncodePNG(&c.buf)
+}
+
+// 本地渲染公式成 png，mtex 不支持上下标等写法，失败时转成 unicode 文本再绘制
+func renderLatex(expr string, display bool) ([]byte, error) {
+	data, err := renderMtex(expr, display)
+	if err == nil {
+		return data, nil
+	}
+	logrus.Debugf("mtex 渲染公式失败 %q: %v", expr, err)
+	return renderUnicode(formula.Unicode(expr), display)
+}
+
+// mtex 遇到不支持的写法会 panic，转成 error
+func renderMtex(expr string, display bool) (data []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	size := latexSize
+	if display {
+		size = latexDisplay
+	}
+
+	var c latexCanvas
+	if err = mtex.Render(&c, "$"+expr+"$", size, latexDpi, nil); err != nil {
+		return nil, err
+	}
+	return c.buf.Bytes(), nil
+}
+
+// 和 mtex 的输出保持相同的像素大小
+func renderUnicode(tex string, display bool) ([]byte, error) {
+	size := latexSize
+	if display {
+		size = latexDisplay
+	}
+	// 内置字体，不依赖需要下载的字体文件
+	face, err := gg.ParseFontFace(dejavusans.TTF, size*latexDpi/72)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(tex, "\n")
+	dc := gg.NewContext(1, 1)
+	dc.SetFontFace(face)
+
+	var width float64
+	for _, line := range lines {
+		w, _ := dc.MeasureString(line)
+		width = max(width, w)
+	}
+	lineHeight := dc.FontHeight() * renderSpacing
+
+	dc = gg.NewContext(int(math.Ceil(width))+2*latexPadding, int(math.Ceil(lineHeight*float64(len(lines))))+2*latexPadding)
+	dc.SetColor(color.White)
+	dc.Clear()
+	dc.SetColor(color.Black)
+	dc.SetFontFace(face)
+	for i, line := range lines {
+		dc.DrawStringAnchored(line, latexPadding, latexPadding+lineHeight*(float64(i)+0.5), 0, 0.35)
+	}
+
+	var buf bytes.Buffer
+	if err = dc.EncodePNG(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 文本和公式图片交替组成消息，渲染失败的公式转成 unicode 文本
+func latexChain(tex string) message.Message {
+	if !Db.OptionBool("latex", true) || !formula.Has(tex) {
+		return message.Message{message.Text(tex)}
+	}
+
+	var chain message.Message
+	for _, seg := range formula.Split(tex) {
+		if !seg.Math {
+			chain = append(chain, message.Text(seg.Text))
+			continue
+		}
+
+		data, err := renderLatex(seg.Text, seg.Display)
+		if err != nil {
+			logrus.Warnf("渲染公式失败 %q: %v", seg.Text, err)
+			chain = append(chain, message.Text(formula.Unicode(seg.Text)))
+			continue
+		}
+		chain = append(chain, message.ImageBytes(data))
+	}
+	return chain
+}
@@ -0,0 +1,90 @@
+package llm
+
+import (
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bincooo/zerobot-llm/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// Prometheus 文本格式的指标，设置环境变量 LLM_METRICS_ADDR (如 :9091) 后开启监听
+var (
+	requestsTotal = metrics.NewCounter("llm_requests_total",
+		"对话请求数", "key", "model", "outcome")
+	requestSeconds = metrics.NewHistogram("llm_request_duration_seconds",
+		"对话请求耗时", []float64{.5, 1, 2.5, 5, 10, 20, 40, 80, 160}, "key", "model")
+	firstTokenSeconds = metrics.NewHistogram("llm_time_to_first_token_seconds",
+		"首个token响应耗时", []float64{.1, .25, .5, 1, 2, 4, 8, 16, 32}, "key", "model")
+	streamedTokens = metrics.NewCounter("llm_streamed_tokens_total",
+		"流式接收的token数 (按增量块计)", "key", "model")
+	activeStreams = metrics.NewGauge("llm_active_streams",
+		"进行中的流式请求数")
+	rateLimited = metrics.NewCounter("llm_ratelimit_rejections_total",
+		"被限流拒绝的请求数", "reason")
+	imitateTriggers = metrics.NewCounter("llm_imitate_triggers_total",
+		"模仿模式自动应答触发次数")
+
+	collectors = []metrics.Collector{
+		requestsTotal,
+		requestSeconds,
+		firstTokenSeconds,
+		streamedTokens,
+		activeStreams,
+		rateLimited,
+		imitateTriggers,
+	}
+)
+
+// 流式响应观测，记录首token耗时以及token数
+type observer struct {
+	labels []string
+	start  time.Time
+	once   sync.Once
+}
+
+func init() {
+	if addr := os.Getenv("LLM_METRICS_ADDR"); addr != "" {
+		go serveMetrics(addr)
+	}
+}
+
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		metrics.WriteAll(w, collectors...)
+	})
+
+	logrus.Infof("llm metrics 监听地址: %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		logrus.Error("llm metrics 监听失败: ", err)
+	}
+}
+
+// start 为发出请求的时间，首字耗时包含建立连接和等待响应头
+func newObserver(start time.Time, labels ...string) *observer {
+	return &observer{labels: labels, start: start}
+}
+
+func (o *observer) onText() {
+	if o == nil {
+		return
+	}
+	o.once.Do(func() {
+		firstTokenSeconds.Observe(time.Since(o.start).Seconds(), o.labels...)
+	})
+	streamedTokens.Inc(o.labels...)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLabelEscape(t *testing.T) {
+	for _, c := range []struct {
+		value, want string
+	}{
+		{"gpt-4o", `k="gpt-4o"`},
+		{`a"b\c`, `k="a\"b\\c"`},
+		{"a\nb", `k="a\nb"`},
+		// 制表符、中文、emoji 原样输出，不能出现 \t \x \u 转义
+		{"a\tb", "k=\"a\tb\""},
+		{"人设😀", `k="人设😀"`},
+		{"\x01", "k=\"\x01\""},
+	} {
+		if got := formatLabels([]string{"k"}, []string{c.value}); got != "{"+c.want+"}" {
+			t.Errorf("formatLabels(%q) = %s", c.value, got)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	requests := NewCounter("requests_total", "请求数", "key", "outcome")
+	requests.Inc(`a"b`, "ok")
+	requests.Add(2, "人设", "ok")
+	active := NewGauge("active", "进行中")
+	active.Inc()
+	active.Inc()
+	active.Dec()
+	seconds := NewHistogram("seconds", "耗时\n秒", []float64{1, 5}, "key")
+	seconds.Observe(0.5, "k")
+	seconds.Observe(3, "k")
+
+	var buf strings.Builder
+	WriteAll(&buf, requests, active, seconds)
+	want := `# HELP requests_total 请求数
+# TYPE requests_total counter
+requests_total{key="a\"b",outcome="ok"} 1
+requests_total{key="人设",outcome="ok"} 2
+# HELP active 进行中
+# TYPE active gauge
+active 1
+# HELP seconds 耗时\n秒
+# TYPE seconds histogram
+seconds_bucket{key="k",le="1"} 1
+seconds_bucket{key="k",le="5"} 2
+seconds_bucket{key="k",le="+Inf"} 2
+seconds_sum{key="k"} 3.5
+seconds_count{key="k"} 2
+`
+	if got := buf.String(); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
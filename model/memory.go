@@ -0,0 +1,327 @@
+package model
+
+import (
+	"cmp"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory 内存存储，用于测试 | 不需要持久化的场景
+type Memory struct {
+	sync.Mutex
+	keys      map[string]Key
+	config    *Config
+	options   map[string]string
+	histories map[int64]History
+	heads     map[string]Head
+	lastId    int64
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		keys:      make(map[string]Key),
+		options:   make(map[string]string),
+		histories: make(map[int64]History),
+		heads:     make(map[string]Head),
+	}
+}
+
+func (m *Memory) SaveKey(k Key) error {
+	m.Lock()
+	defer m.Unlock()
+	k = newKey(k)
+	if old, ok := m.keys[k.Name]; ok {
+		k.Model, k.Created, k.LastUsed, k.Health = old.Model, old.Created, old.LastUsed, old.Health
+	}
+	m.keys[k.Name] = k
+	return nil
+}
+
+func (m *Memory) UseKey(name, model, health string) error {
+	m.Lock()
+	defer m.Unlock()
+	if k, ok := m.keys[name]; ok {
+		k.Model, k.LastUsed, k.Health = model, time.Now().Unix(), health
+		m.keys[name] = k
+	}
+	return nil
+}
+
+func (m *Memory) DelKey(name string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.keys, name)
+	return nil
+}
+
+func (m *Memory) Keys() ([]*Key, error) {
+	m.Lock()
+	defer m.Unlock()
+	var keys []*Key
+	for _, k := range m.keys {
+		k := k
+		keys = append(keys, &k)
+	}
+	if len(keys) == 0 {
+		return nil, ErrNullResult
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys, nil
+}
+
+func (m *Memory) Key(name string) (*Key, error) {
+	m.Lock()
+	defer m.Unlock()
+	k, ok := m.keys[name]
+	if !ok {
+		return &k, ErrNullResult
+	}
+	return &k, nil
+}
+
+func (m *Memory) Config() Config {
+	m.Lock()
+	defer m.Unlock()
+	if m.config == nil {
+		return defaultConfig()
+	}
+	return *m.config
+}
+
+func (m *Memory) UpdateConfig(c Config) error {
+	m.Lock()
+	defer m.Unlock()
+	c.Timestamp = -1
+	m.config = &c
+	return nil
+}
+
+func (m *Memory) Option(name, def string) string {
+	m.Lock()
+	defer m.Unlock()
+	if value, ok := m.options[name]; ok {
+		return value
+	}
+	return def
+}
+
+func (m *Memory) OptionInt(name string, def int) int {
+	return parseInt(m.Option(name, ""), def)
+}
+
+func (m *Memory) OptionBool(name string, def bool) bool {
+	return parseBool(m.Option(name, ""), def)
+}
+
+func (m *Memory) SetOption(name, value string) error {
+	m.Lock()
+	defer m.Unlock()
+	m.options[name] = value
+	return nil
+}
+
+func (m *Memory) SaveHistory(h History) error {
+	m.Lock()
+	defer m.Unlock()
+	k := conversation(h.Uid, h.Name)
+	m.lastId++
+	h.Id = m.lastId
+	h.Parent = m.heads[k].HistoryId
+	m.histories[h.Id] = h
+	m.heads[k] = Head{k, h.Uid, h.Name, h.Id}
+	return nil
+}
+
+func (m *Memory) FindHistory(uid int64, name string, count int) ([]*History, error) {
+	m.Lock()
+	defer m.Unlock()
+	var histories []*History
+	for id := m.heads[conversation(uid, name)].HistoryId; len(histories) < count; {
+		h, ok := m.histories[id]
+		if !ok || h.Uid != uid || h.Name != name {
+			break
+		}
+		histories = append(histories, &h)
+		id = h.Parent
+	}
+	if len(histories) == 0 {
+		return nil, ErrNullResult
+	}
+	return histories, nil
+}
+
+func (m *Memory) History(id int64) (*History, error) {
+	m.Lock()
+	defer m.Unlock()
+	h, ok := m.histories[id]
+	if !ok {
+		return &h, ErrNullResult
+	}
+	return &h, nil
+}
+
+func (m *Memory) Branches(uid int64, name string) ([]*History, error) {
+	m.Lock()
+	defer m.Unlock()
+	head := m.heads[conversation(uid, name)].HistoryId
+	var branches []*History
+	for _, h := range m.histories {
+		if h.Uid == uid && h.Name == name && h.Id != head && !m.hasChild(h.Id) {
+			h := h
+			branches = append(branches, &h)
+		}
+	}
+	if len(branches) == 0 {
+		return nil, ErrNullResult
+	}
+	sort.Slice(branches, func(i, j int) bool { return branches[i].Id > branches[j].Id })
+	return branches, nil
+}
+
+func (m *Memory) LastHistory(uid int64, name string) (*History, error) {
+	hs, err := m.FindHistory(uid, name, 1)
+	if err != nil {
+		return nil, err
+	}
+	return hs[0], nil
+}
+
+func (m *Memory) DelHistory(h *History) error {
+	m.Lock()
+	defer m.Unlock()
+	m.setHead(h.Uid, h.Name, h.Parent)
+	if !m.hasChild(h.Id) {
+		delete(m.histories, h.Id)
+	}
+	return nil
+}
+
+func (m *Memory) Checkout(uid int64, name string, id int64) error {
+	m.Lock()
+	defer m.Unlock()
+	m.setHead(uid, name, id)
+	return nil
+}
+
+func (m *Memory) CleanHistories(uid int64, name string) error {
+	return m.clean(func(h History) bool { return h.Uid == uid && h.Name == name })
+}
+
+func (m *Memory) CleanAllHistories(name string) error {
+	return m.clean(func(h History) bool { return h.Name == name })
+}
+
+func (m *Memory) PruneHistories(before int64, rows int) (int64, error) {
+	m.Lock()
+	defer m.Unlock()
+	var count int64
+	if before > 0 {
+		for id, h := range m.histories {
+			if h.Timestamp < before {
+				delete(m.histories, id)
+				count++
+			}
+		}
+	}
+
+	if rows > 0 {
+		groups := make(map[string][]History)
+		for _, h := range m.histories {
+			k := conversation(h.Uid, h.Name)
+			groups[k] = append(groups[k], h)
+		}
+		for _, hs := range groups {
+			slices.SortFunc(hs, func(a, b History) int {
+				if c := cmp.Compare(b.Timestamp, a.Timestamp); c != 0 {
+					return c
+				}
+				return cmp.Compare(b.Id, a.Id)
+			})
+			for i := rows; i < len(hs); i++ {
+				delete(m.histories, hs[i].Id)
+				count++
+			}
+		}
+	}
+
+	for k, head := range m.heads {
+		if h, ok := m.histories[head.HistoryId]; head.HistoryId != 0 && (!ok || h.Uid != head.Uid || h.Name != head.Name) {
+			delete(m.heads, k)
+		}
+	}
+	for id, h := range m.histories {
+		if _, ok := m.histories[h.Parent]; h.Parent != 0 && !ok {
+			h.Parent = 0
+			m.histories[id] = h
+		}
+	}
+	return count, nil
+}
+
+func (m *Memory) Usages(limit int) ([]*Usage, error) {
+	m.Lock()
+	defer m.Unlock()
+	usages := make(map[int64]*Usage)
+	for _, h := range m.histories {
+		u, ok := usages[h.Uid]
+		if !ok {
+			u = &Usage{Uid: h.Uid}
+			usages[h.Uid] = u
+		}
+		u.Rows++
+		u.Bytes += int64(len(h.UserContent) + len(h.AssistantContent))
+	}
+
+	var values []*Usage
+	for _, u := range usages {
+		values = append(values, u)
+	}
+	if len(values) == 0 {
+		return nil, ErrNullResult
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].Bytes > values[j].Bytes })
+	if len(values) > limit {
+		values = values[:limit]
+	}
+	return values, nil
+}
+
+func (m *Memory) Vacuum() error {
+	return nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+func (m *Memory) clean(match func(h History) bool) error {
+	m.Lock()
+	defer m.Unlock()
+	for id, h := range m.histories {
+		if match(h) {
+			delete(m.histories, id)
+		}
+	}
+	for k, head := range m.heads {
+		if match(History{Uid: head.Uid, Name: head.Name}) {
+			delete(m.heads, k)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) setHead(uid int64, name string, id int64) {
+	k := conversation(uid, name)
+	m.heads[k] = Head{k, uid, name, id}
+}
+
+func (m *Memory) hasChild(id int64) bool {
+	for _, h := range m.histories {
+		if h.Parent == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,280 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 数据库结构版本，按顺序执行，已执行的记录在 schema_version 表。
+// 新增字段 | 表时在末尾追加，不要修改已发布的迁移
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+var migrations = []migration{
+	{1, "初始表结构", migrateBase},
+	{2, "配置项表", migrateOption},
+	{3, "对话记录分支", migrateHistoryBranch},
+	{4, "加密 key", encryptKeys},
+	{5, "key 使用情况", migrateKeyUsage},
+	{6, "对话记录自增 id", migrateHistoryAutoincrement},
+	{7, "清理失效的分支末端", pruneHeads},
+	{8, "对话记录格式", migrateHistoryFormat},
+}
+
+// 执行未完成的迁移，每个迁移在单独的事务中完成
+func (d *DB) migrate() error {
+	_, err := d.sql.DB.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		Version INTEGER NOT NULL PRIMARY KEY,
+		Name TEXT NOT NULL,
+		Timestamp BIGINT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	current, err := d.schemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		tx, err := d.sql.DB.Begin()
+		if err != nil {
+			return err
+		}
+
+		if err = m.up(tx); err == nil {
+			_, err = tx.Exec("INSERT INTO schema_version (Version, Name, Timestamp) VALUES (?, ?, ?)", m.version, m.name, time.Now().Unix())
+		}
+		if err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SchemaVersion 当前数据库结构版本
+func (d *DB) SchemaVersion() (int, error) {
+	d.Lock()
+	defer d.Unlock()
+	return d.schemaVersion()
+}
+
+func (d *DB) schemaVersion() (version int, err error) {
+	err = d.sql.DB.QueryRow("SELECT IFNULL(MAX(Version), 0) FROM schema_version").Scan(&version)
+	return
+}
+
+// 引入版本管理之前的表结构，已存在时跳过
+func migrateBase(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE IF NOT EXISTS 'Key' (
+			Name TEXT NOT NULL PRIMARY KEY,
+			Content TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS 'History' (
+			Timestamp BIGINT NOT NULL PRIMARY KEY,
+			Uid BIGINT NOT NULL,
+			Name TEXT NOT NULL,
+			UserContent TEXT NOT NULL,
+			AssistantContent TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS 'config' (
+			Timestamp BIGINT NOT NULL PRIMARY KEY,
+			Proxies TEXT NOT NULL,
+			BaseUrl TEXT NOT NULL,
+			Key TEXT NOT NULL,
+			Model TEXT NOT NULL,
+			Imitate BOOLEAN NOT NULL,
+			Freq INTEGER NOT NULL
+		)`)
+}
+
+func migrateOption(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE IF NOT EXISTS 'option' (
+			Name TEXT NOT NULL PRIMARY KEY,
+			Value TEXT NOT NULL
+		)`)
+}
+
+// History 增加 id、parent，旧记录按时间串成单一分支
+func migrateHistoryBranch(tx *sql.Tx) error {
+	err := execAll(tx,
+		`CREATE TABLE IF NOT EXISTS 'Head' (
+			Conversation TEXT NOT NULL PRIMARY KEY,
+			Uid BIGINT NOT NULL,
+			Name TEXT NOT NULL,
+			HistoryId BIGINT NOT NULL
+		)`)
+	if err != nil {
+		return err
+	}
+
+	columns, err := tableColumns(tx, "History")
+	if err != nil || slices.Contains(columns, "id") {
+		return err
+	}
+
+	err = execAll(tx,
+		"ALTER TABLE History RENAME TO History_old",
+		`CREATE TABLE 'History' (
+			Id BIGINT NOT NULL PRIMARY KEY,
+			Parent BIGINT NOT NULL,
+			Timestamp BIGINT NOT NULL,
+			Uid BIGINT NOT NULL,
+			Name TEXT NOT NULL,
+			UserContent TEXT NOT NULL,
+			AssistantContent TEXT NOT NULL
+		)`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT Timestamp, Uid, Name, UserContent, AssistantContent FROM History_old ORDER BY Timestamp ASC")
+	if err != nil {
+		return err
+	}
+
+	var histories []History
+	for rows.Next() {
+		var h History
+		if err = rows.Scan(&h.Timestamp, &h.Uid, &h.Name, &h.UserContent, &h.AssistantContent); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		histories = append(histories, h)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	heads := make(map[string]int64)
+	for i, h := range histories {
+		k := conversation(h.Uid, h.Name)
+		h.Id = int64(i + 1)
+		h.Parent = heads[k]
+		_, err = tx.Exec("INSERT INTO History (Id, Parent, Timestamp, Uid, Name, UserContent, AssistantContent) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			h.Id, h.Parent, h.Timestamp, h.Uid, h.Name, h.UserContent, h.AssistantContent)
+		if err != nil {
+			return err
+		}
+		heads[k] = h.Id
+
+		_, err = tx.Exec("REPLACE INTO Head (Conversation, Uid, Name, HistoryId) VALUES (?, ?, ?, ?)", k, h.Uid, h.Name, h.Id)
+		if err != nil {
+			return err
+		}
+	}
+	return execAll(tx, "DROP TABLE History_old")
+}
+
+// Key 增加提供方、尾号、使用时间等，旧 key 的尾号从明文补上
+func migrateKeyUsage(tx *sql.Tx) error {
+	err := execAll(tx,
+		"ALTER TABLE \"Key\" ADD COLUMN Provider TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE \"Key\" ADD COLUMN Model TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE \"Key\" ADD COLUMN Hint TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE \"Key\" ADD COLUMN Created BIGINT NOT NULL DEFAULT 0",
+		"ALTER TABLE \"Key\" ADD COLUMN LastUsed BIGINT NOT NULL DEFAULT 0",
+		"ALTER TABLE \"Key\" ADD COLUMN Health TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT Name, Content FROM \"Key\"")
+	if err != nil {
+		return err
+	}
+
+	var keys []Key
+	for rows.Next() {
+		var k Key
+		if err = rows.Scan(&k.Name, &k.Content); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		keys = append(keys, k)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		secret, err := Decrypt(&k)
+		if err != nil {
+			continue
+		}
+		if _, err = tx.Exec("UPDATE \"Key\" SET Hint = ? WHERE Name = ?", maskSecret(secret), k.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 改为 AUTOINCREMENT，删除过的 id 不会再分配给新记录
+func migrateHistoryAutoincrement(tx *sql.Tx) error {
+	return execAll(tx,
+		"ALTER TABLE History RENAME TO History_old",
+		`CREATE TABLE 'History' (
+			Id INTEGER PRIMARY KEY AUTOINCREMENT,
+			Parent BIGINT NOT NULL,
+			Timestamp BIGINT NOT NULL,
+			Uid BIGINT NOT NULL,
+			Name TEXT NOT NULL,
+			UserContent TEXT NOT NULL,
+			AssistantContent TEXT NOT NULL
+		)`,
+		`INSERT INTO History (Id, Parent, Timestamp, Uid, Name, UserContent, AssistantContent)
+			SELECT Id, Parent, Timestamp, Uid, Name, UserContent, AssistantContent FROM History_old`,
+		"DROP TABLE History_old")
+}
+
+// 结构化格式单独记录，不再从提问内容推断。旧记录一律按文本处理
+func migrateHistoryFormat(tx *sql.Tx) error {
+	return execAll(tx, "ALTER TABLE History ADD COLUMN Format TEXT NOT NULL DEFAULT ''")
+}
+
+func execAll(tx *sql.Tx, queries ...string) error {
+	for _, q := range queries {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func tableColumns(tx *sql.Tx, table string) ([]string, error) {
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err = rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns = append(columns, strings.ToLower(column))
+	}
+	return columns, rows.Err()
+}
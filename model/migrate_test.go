@@ -0,0 +1,104 @@
+package model
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	sqlite "github.com/FloatTech/sqlite"
+)
+
+// 从引入版本管理之前的数据库升级
+func TestMigrateBaseline(t *testing.T) {
+	sqlite.DriverName = "sqlite"
+	path := filepath.Join(t.TempDir(), "data.DB")
+	fixture, err := os.ReadFile("testdata/baseline.sql")
+	must(t, err)
+
+	raw, err := sql.Open(sqlite.DriverName, path)
+	must(t, err)
+	_, err = raw.Exec(string(fixture))
+	must(t, err)
+	must(t, raw.Close())
+
+	var d DB
+	must(t, d.Open(path))
+	defer d.Close()
+
+	checkVersion(t, &d)
+
+	k, err := d.Key("claude")
+	must(t, err)
+	if k.Content != "sk-claude" || k.Hint != "****aude" || k.Health != "" {
+		t.Fatalf("key = %+v", k)
+	}
+
+	c := d.Config()
+	if c.Key != "gpt" || !c.Imitate || c.Freq != 30 {
+		t.Fatalf("config = %+v", c)
+	}
+
+	// 旧记录按时间串成单一分支，最新的在前
+	hs, err := d.FindHistory(100, "gpt", 10)
+	must(t, err)
+	var contents []string
+	for _, h := range hs {
+		contents = append(contents, h.UserContent)
+	}
+	if len(hs) != 3 || contents[0] != "u5" || contents[1] != "u3" || contents[2] != "u1" {
+		t.Fatalf("history = %v", contents)
+	}
+	if hs[2].Parent != 0 || hs[1].Parent != hs[2].Id || hs[0].Parent != hs[1].Id {
+		t.Fatalf("chain = %+v", hs)
+	}
+
+	for _, c := range []struct {
+		uid  int64
+		name string
+		want string
+	}{{200, "gpt", "u2"}, {100, "claude", "u4"}} {
+		hs, err = d.FindHistory(c.uid, c.name, 10)
+		must(t, err)
+		if len(hs) != 1 || hs[0].UserContent != c.want {
+			t.Fatalf("history(%d, %s) = %+v", c.uid, c.name, hs)
+		}
+	}
+
+	// 升级后可以继续写入
+	must(t, d.SaveHistory(History{Timestamp: 1700000006, Uid: 100, Name: "gpt", UserContent: "u6", AssistantContent: "a6"}))
+	hs, err = d.FindHistory(100, "gpt", 10)
+	must(t, err)
+	if len(hs) != 4 || hs[0].UserContent != "u6" || hs[0].Parent != hs[1].Id {
+		t.Fatalf("history after save = %+v", hs)
+	}
+
+	// 再次打开不会重复执行
+	must(t, d.Close())
+	must(t, d.Open(path))
+	checkVersion(t, &d)
+	if hs, err = d.FindHistory(100, "gpt", 10); err != nil || len(hs) != 4 {
+		t.Fatalf("history after reopen = %+v, %v", hs, err)
+	}
+}
+
+func TestMigrateFresh(t *testing.T) {
+	d := openDB(t)
+	checkVersion(t, d)
+
+	must(t, d.SaveKey(Key{Name: "gpt", Content: "sk"}))
+	must(t, d.SaveHistory(History{Timestamp: 1, Uid: 1, Name: "gpt", UserContent: "u", AssistantContent: "a"}))
+	must(t, d.SetOption("queue", "false"))
+	if ok := d.OptionBool("queue", true); ok {
+		t.Fatal("option not saved")
+	}
+}
+
+func checkVersion(t *testing.T, d *DB) {
+	t.Helper()
+	version, err := d.SchemaVersion()
+	must(t, err)
+	if latest := migrations[len(migrations)-1].version; version != latest {
+		t.Fatalf("schema version = %d, want %d", version, latest)
+	}
+}
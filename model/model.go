@@ -0,0 +1,480 @@
+// Package model llm 插件的数据存储
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	sqlite "github.com/FloatTech/sqlite"
+)
+
+// ErrNullResult 没有查询到结果
+var ErrNullResult = sqlite.ErrNullResult
+
+// DB sqlite 存储
+type DB struct {
+	sql *sqlite.Sqlite
+	sync.RWMutex
+}
+
+type Key struct {
+	Name    string `DB:"name"`
+	Content string `DB:"value"`
+
+	Provider string `DB:"provider"` // 保存时 baseUrl 的域名
+	Model    string `DB:"model"`    // 最近一次使用的模型
+	Hint     string `DB:"hint"`     // 脱敏后的尾号
+	Created  int64  `DB:"created"`
+	LastUsed int64  `DB:"last_used"`
+	Health   string `DB:"health"` // 最近一次请求结果
+}
+
+// 最近一次请求结果
+const (
+	HealthOk      = "ok"
+	HealthAuth    = "auth"
+	HealthLimited = "limited"
+	HealthError   = "error"
+)
+
+type Config struct {
+	Timestamp int64  `DB:"timestamp"`
+	Proxies   string `DB:"proxies"`
+	BaseUrl   string `DB:"base_url"`
+	Key       string `DB:"key"`
+	Model     string `DB:"model"`
+	Imitate   bool   `DB:"imitate"` // 模仿模式
+	Freq      int    `DB:"freq"`    // 模仿模式自动应答频率0~100
+}
+
+// 其它零散配置项
+type option struct {
+	Name  string `DB:"name"`
+	Value string `DB:"value"`
+}
+
+// History 对话记录，Parent 指向上一轮对话，构成分支树
+type History struct {
+	Id        int64  `DB:"id"`
+	Parent    int64  `DB:"parent"`
+	Timestamp int64  `DB:"timestamp"`
+	Uid       int64  `DB:"uid"`
+	Name      string `DB:"name"`
+
+	UserContent      string `DB:"user_content"`
+	AssistantContent string `DB:"assistant_content"`
+
+	// 提问的格式，FormatStructured 时 UserContent 是机器人生成的发言人列表
+	Format string `DB:"format"`
+}
+
+// 对话记录的提问格式，旧记录为空，按文本处理
+const (
+	FormatText       = "text"
+	FormatStructured = "structured"
+)
+
+// Head 对话当前所在分支的末端
+type Head struct {
+	Conversation string `DB:"conversation"` // uid:name
+	Uid          int64  `DB:"uid"`
+	Name         string `DB:"name"`
+	HistoryId    int64  `DB:"history_id"`
+}
+
+// Usage 各聊天室对话记录占用
+type Usage struct {
+	Uid   int64
+	Rows  int64
+	Bytes int64
+}
+
+// IsNull 是否为空结果
+func IsNull(err error) bool {
+	return errors.Is(err, ErrNullResult)
+}
+
+// Open 打开数据库并执行未完成的迁移
+func (d *DB) Open(path string) error {
+	d.Lock()
+	defer d.Unlock()
+	if err := checkMasterKeyFile(path); err != nil {
+		return err
+	}
+
+	d.sql = &sqlite.Sqlite{DBPath: path}
+	err := d.sql.Open(time.Hour * 24)
+	if err != nil {
+		return err
+	}
+
+	if err = d.migrate(); err != nil {
+		return err
+	}
+
+	// 迁移之后才配置主密钥的，打开时补上加密
+	tx, err := d.sql.DB.Begin()
+	if err != nil {
+		return err
+	}
+	if err = encryptKeys(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (d *DB) Close() error {
+	d.Lock()
+	defer d.Unlock()
+	return d.sql.Close()
+}
+
+func (d *DB) Path() string {
+	return d.sql.DBPath
+}
+
+// SaveKey 配置了主密钥时加密保存。已存在时只更新密钥和配置，保留创建时间和使用情况
+func (d *DB) SaveKey(k Key) error {
+	d.Lock()
+	defer d.Unlock()
+	master, err := masterKey()
+	if err != nil {
+		return err
+	}
+	if k, err = encrypt(master, newKey(k)); err != nil {
+		return err
+	}
+	return d.exec(`INSERT INTO "Key" (Name, Content, Provider, Model, Hint, Created, LastUsed, Health)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(Name) DO UPDATE SET Content = excluded.Content, Provider = excluded.Provider, Hint = excluded.Hint`,
+		k.Name, k.Content, k.Provider, k.Model, k.Hint, k.Created, k.LastUsed, k.Health)
+}
+
+func (d *DB) DelKey(name string) error {
+	d.Lock()
+	defer d.Unlock()
+	return d.exec("DELETE FROM \"Key\" WHERE name = ?", name)
+}
+
+func (d *DB) Keys() ([]*Key, error) {
+	d.Lock()
+	defer d.Unlock()
+	return queryAll[Key](d, "SELECT * FROM \"Key\"")
+}
+
+func (d *DB) Key(name string) (*Key, error) {
+	d.Lock()
+	defer d.Unlock()
+	var k Key
+	err := d.find(&k, "SELECT * FROM \"Key\" WHERE name = ?", name)
+	return &k, err
+}
+
+// UseKey 记录 key 的使用情况
+func (d *DB) UseKey(name, model, health string) error {
+	d.Lock()
+	defer d.Unlock()
+	return d.exec("UPDATE \"Key\" SET Model = ?, LastUsed = ?, Health = ? WHERE Name = ?", model, time.Now().Unix(), health, name)
+}
+
+func (d *DB) Config() Config {
+	d.Lock()
+	defer d.Unlock()
+	c := defaultConfig()
+	_ = d.sql.Find("config", &c, "")
+	return c
+}
+
+func (d *DB) UpdateConfig(c Config) error {
+	d.Lock()
+	defer d.Unlock()
+	c.Timestamp = -1
+	return d.sql.Insert("config", &c)
+}
+
+func (d *DB) Option(name, def string) string {
+	d.Lock()
+	defer d.Unlock()
+	var o option
+	if err := d.find(&o, "SELECT * FROM option WHERE name = ?", name); err != nil {
+		return def
+	}
+	return o.Value
+}
+
+func (d *DB) OptionInt(name string, def int) int {
+	return parseInt(d.Option(name, ""), def)
+}
+
+func (d *DB) OptionBool(name string, def bool) bool {
+	return parseBool(d.Option(name, ""), def)
+}
+
+func (d *DB) SetOption(name, value string) error {
+	d.Lock()
+	defer d.Unlock()
+	return d.sql.Insert("option", &option{name, value})
+}
+
+// SaveHistory 保存到当前分支末端，并移动末端指针
+func (d *DB) SaveHistory(h History) error {
+	d.Lock()
+	defer d.Unlock()
+	head, err := d.head(h.Uid, h.Name)
+	if err != nil {
+		return err
+	}
+
+	h.Parent = head
+	result, err := d.sql.DB.Exec(`INSERT INTO History (Parent, Timestamp, Uid, Name, UserContent, AssistantContent, Format)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, h.Parent, h.Timestamp, h.Uid, h.Name, h.UserContent, h.AssistantContent, h.Format)
+	if err != nil {
+		return err
+	}
+
+	if h.Id, err = result.LastInsertId(); err != nil {
+		return err
+	}
+	return d.setHead(h.Uid, h.Name, h.Id)
+}
+
+// FindHistory 沿当前分支向上查找，按时间倒序返回
+func (d *DB) FindHistory(uid int64, name string, count int) ([]*History, error) {
+	d.Lock()
+	defer d.Unlock()
+	head, err := d.head(uid, name)
+	if err != nil {
+		return nil, err
+	}
+	return d.path(uid, name, head, count)
+}
+
+func (d *DB) History(id int64) (*History, error) {
+	d.Lock()
+	defer d.Unlock()
+	var h History
+	err := d.find(&h, "SELECT * FROM History WHERE id = ?", id)
+	return &h, err
+}
+
+// Branches 当前分支之外的分支末端
+func (d *DB) Branches(uid int64, name string) ([]*History, error) {
+	d.Lock()
+	defer d.Unlock()
+	head, err := d.head(uid, name)
+	if err != nil {
+		return nil, err
+	}
+	return queryAll[History](d, `SELECT * FROM History WHERE uid = ? AND name = ? AND id != ?
+		AND id NOT IN (SELECT parent FROM History) ORDER BY id DESC`, uid, name, head)
+}
+
+func (d *DB) LastHistory(uid int64, name string) (*History, error) {
+	hs, err := d.FindHistory(uid, name, 1)
+	if err != nil {
+		return nil, err
+	}
+	return hs[0], nil
+}
+
+// DelHistory 末端回退到上一轮，没有其它分支引用时删除该记录
+func (d *DB) DelHistory(h *History) error {
+	d.Lock()
+	defer d.Unlock()
+	if err := d.setHead(h.Uid, h.Name, h.Parent); err != nil {
+		return err
+	}
+
+	var child History
+	err := d.find(&child, "SELECT * FROM History WHERE parent = ? LIMIT 1", h.Id)
+	if err == nil || !IsNull(err) {
+		return err
+	}
+	return d.exec("DELETE FROM History WHERE id = ?", h.Id)
+}
+
+// Checkout 切换当前分支，id 为 0 时回到对话开始
+func (d *DB) Checkout(uid int64, name string, id int64) error {
+	d.Lock()
+	defer d.Unlock()
+	return d.setHead(uid, name, id)
+}
+
+func (d *DB) CleanHistories(uid int64, name string) error {
+	d.Lock()
+	defer d.Unlock()
+	if err := d.exec("DELETE FROM History WHERE uid = ? AND name = ?", uid, name); err != nil {
+		return err
+	}
+	return d.exec("DELETE FROM Head WHERE uid = ? AND name = ?", uid, name)
+}
+
+func (d *DB) CleanAllHistories(name string) error {
+	d.Lock()
+	defer d.Unlock()
+	if err := d.exec("DELETE FROM History WHERE name = ?", name); err != nil {
+		return err
+	}
+	return d.exec("DELETE FROM Head WHERE name = ?", name)
+}
+
+// PruneHistories 按时间 | 条数清理对话记录，返回删除的条数。
+// 同一事务中移除指向已删除记录的末端，并把断开的 parent 置 0
+func (d *DB) PruneHistories(before int64, rows int) (int64, error) {
+	d.Lock()
+	defer d.Unlock()
+	if d.sql == nil || d.sql.DB == nil {
+		return 0, sqlite.ErrNilDB
+	}
+
+	tx, err := d.sql.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	exec := func(q string, args ...interface{}) error {
+		result, err := tx.Exec(q, args...)
+		if err != nil {
+			return err
+		}
+		n, _ := result.RowsAffected()
+		count += n
+		return nil
+	}
+
+	if before > 0 {
+		err = exec("DELETE FROM History WHERE timestamp < ?", before)
+	}
+	if err == nil && rows > 0 {
+		err = exec(`DELETE FROM History WHERE id IN (
+			SELECT id FROM (
+				SELECT id, ROW_NUMBER() OVER (PARTITION BY uid, name ORDER BY timestamp DESC, id DESC) AS rn FROM History
+			) WHERE rn > ?
+		)`, rows)
+	}
+	if err == nil {
+		err = pruneHeads(tx)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+	return count, tx.Commit()
+}
+
+// 删除失效的末端 (记录已删除 | 不属于该对话)，断开的 parent 置 0
+func pruneHeads(tx *sql.Tx) error {
+	return execAll(tx,
+		`DELETE FROM Head WHERE HistoryId != 0 AND NOT EXISTS (
+			SELECT 1 FROM History h WHERE h.Id = Head.HistoryId AND h.Uid = Head.Uid AND h.Name = Head.Name
+		)`,
+		"UPDATE History SET Parent = 0 WHERE Parent != 0 AND Parent NOT IN (SELECT Id FROM History)")
+}
+
+func (d *DB) Vacuum() error {
+	d.Lock()
+	defer d.Unlock()
+	return d.exec("VACUUM")
+}
+
+func (d *DB) Usages(limit int) ([]*Usage, error) {
+	d.Lock()
+	defer d.Unlock()
+	return queryAll[Usage](d, `SELECT uid, COUNT(1), IFNULL(SUM(LENGTH(CAST(UserContent AS BLOB)) + LENGTH(CAST(AssistantContent AS BLOB))), 0) AS bytes
+		FROM History GROUP BY uid ORDER BY bytes DESC LIMIT ?`, limit)
+}
+
+func (d *DB) head(uid int64, name string) (int64, error) {
+	var h Head
+	err := d.find(&h, "SELECT * FROM Head WHERE conversation = ?", conversation(uid, name))
+	if err != nil {
+		if IsNull(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return h.HistoryId, nil
+}
+
+func (d *DB) setHead(uid int64, name string, id int64) error {
+	return d.sql.Insert("Head", &Head{conversation(uid, name), uid, name, id})
+}
+
+// 只沿同一对话的记录向上查找，失效的 parent | head 不会串到其它对话
+func (d *DB) path(uid int64, name string, id int64, count int) ([]*History, error) {
+	return queryAll[History](d, `WITH RECURSIVE path(id, parent, depth) AS (
+			SELECT id, parent, 0 FROM History WHERE id = ? AND uid = ? AND name = ?
+			UNION ALL
+			SELECT h.id, h.parent, path.depth + 1 FROM History h JOIN path ON h.id = path.parent
+				WHERE h.uid = ? AND h.name = ? AND path.depth + 1 < ?
+		)
+		SELECT h.* FROM History h JOIN path ON h.id = path.id ORDER BY path.depth`, id, uid, name, uid, name, count)
+}
+
+func conversation(uid int64, name string) string {
+	return strconv.FormatInt(uid, 10) + ":" + name
+}
+
+// 以下查询全部使用绑定参数，不拼接用户输入
+
+func (d *DB) exec(q string, args ...interface{}) error {
+	if d.sql == nil || d.sql.DB == nil {
+		return sqlite.ErrNilDB
+	}
+	_, err := d.sql.DB.Exec(q, args...)
+	return err
+}
+
+// 查询单条结果写入 objptr，字段与结构体元素顺序一致
+func (d *DB) find(objptr interface{}, q string, args ...interface{}) error {
+	if d.sql == nil || d.sql.DB == nil {
+		return sqlite.ErrNilDB
+	}
+	err := d.sql.DB.QueryRow(q, args...).Scan(addrs(objptr)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNullResult
+	}
+	return err
+}
+
+func queryAll[T any](d *DB, q string, args ...interface{}) ([]*T, error) {
+	if d.sql == nil || d.sql.DB == nil {
+		return nil, sqlite.ErrNilDB
+	}
+	rows, err := d.sql.DB.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []*T
+	for rows.Next() {
+		var v T
+		if err = rows.Scan(addrs(&v)...); err != nil {
+			return nil, err
+		}
+		values = append(values, &v)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, ErrNullResult
+	}
+	return values, nil
+}
+
+func addrs(objptr interface{}) []interface{} {
+	elem := reflect.ValueOf(objptr).Elem()
+	values := make([]interface{}, elem.NumField())
+	for i := range values {
+		values[i] = elem.Field(i).Addr().Interface()
+	}
+	return values
+}
@@ -0,0 +1,114 @@
+package model
+
+import (
+	"path/filepath"
+	"regexp"
+	"testing"
+	"unicode/utf8"
+
+	sqlite "github.com/FloatTech/sqlite"
+)
+
+// 与 /chat、/set-key、/clear 指令中 key 名称的匹配规则一致
+var keyName = regexp.MustCompile(`^\S+$`)
+
+func openDB(t testing.TB) *DB {
+	// 宿主程序会注册 sqlite3 驱动，测试中直接使用 modernc 的 sqlite
+	sqlite.DriverName = "sqlite"
+	var d DB
+	if err := d.Open(filepath.Join(t.TempDir(), "data.DB")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = d.Close() })
+	return &d
+}
+
+func TestKeyNameInjection(t *testing.T) {
+	d := openDB(t)
+	names := []string{
+		"'",
+		"a'b",
+		"x'or'1'='1",
+		"';DELETE/**/FROM/**/History;--",
+		"auto'/**/OR/**/name/**/LIKE/**/'%",
+	}
+	m := NewMemory()
+	for _, name := range names {
+		checkKeyName(t, d, name)
+		checkKeyName(t, m, name)
+	}
+}
+
+func FuzzKeyName(f *testing.F) {
+	for _, seed := range []string{"auto", "gpt", "'", "a'b", "x'or'1'='1", "\"", "\\", "%", "_", "名字", "';DROP/**/TABLE/**/History;--"} {
+		f.Add(seed)
+	}
+
+	d := openDB(f)
+	f.Fuzz(func(t *testing.T, name string) {
+		if !utf8.ValidString(name) || !keyName.MatchString(name) {
+			t.Skip()
+		}
+		checkKeyName(t, d, name)
+	})
+}
+
+// 以 name 走一遍 key 与对话记录的增删查，其它 key 的数据不受影响
+func checkKeyName(t *testing.T, d Store, name string) {
+	const other = "other-key"
+	if name == other {
+		return
+	}
+
+	must(t, d.SaveKey(Key{Name: other, Content: "other"}))
+	must(t, d.SaveHistory(History{Timestamp: 1, Uid: 1, Name: other, UserContent: "u", AssistantContent: "a"}))
+
+	// /set-key
+	must(t, d.SaveKey(Key{Name: name, Content: "secret"}))
+	k, err := d.Key(name)
+	must(t, err)
+	if k.Name != name || k.Content != "secret" {
+		t.Fatalf("key(%q) = %+v", name, k)
+	}
+
+	// /chat
+	must(t, d.SaveHistory(History{Timestamp: 2, Uid: 1, Name: name, UserContent: name, AssistantContent: name}))
+	hs, err := d.FindHistory(1, name, 10)
+	must(t, err)
+	if len(hs) != 1 || hs[0].Name != name || hs[0].UserContent != name {
+		t.Fatalf("findHistory(%q) = %+v", name, hs)
+	}
+
+	must(t, d.CleanHistories(1, name))
+	if _, err = d.FindHistory(1, name, 10); !IsNull(err) {
+		t.Fatalf("cleanHistories(%q) left rows: %v", name, err)
+	}
+
+	// /clear
+	must(t, d.SaveHistory(History{Timestamp: 3, Uid: 2, Name: name, UserContent: "u", AssistantContent: "a"}))
+	must(t, d.CleanAllHistories(name))
+	if _, err = d.FindHistory(2, name, 10); !IsNull(err) {
+		t.Fatalf("cleanAllHistories(%q) left rows: %v", name, err)
+	}
+
+	// /del-key
+	must(t, d.DelKey(name))
+	if _, err = d.Key(name); !IsNull(err) {
+		t.Fatalf("delKey(%q): %v", name, err)
+	}
+
+	if _, err = d.Key(other); err != nil {
+		t.Fatalf("key %q affected by %q: %v", other, name, err)
+	}
+	if hs, err = d.FindHistory(1, other, 10); err != nil || len(hs) == 0 {
+		t.Fatalf("history of %q affected by %q: %v", other, name, err)
+	}
+	must(t, d.CleanHistories(1, other))
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
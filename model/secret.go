@@ -0,0 +1,183 @@
+package model
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 主密钥，LLM_MASTER_KEY 直接给出，或 LLM_MASTER_KEY_FILE 指定文件（不能放在数据目录下）。
+// 32 字节的 base64 直接作为 AES-256 密钥，其它内容取 sha256
+const (
+	masterKeyEnv     = "LLM_MASTER_KEY"
+	masterKeyFileEnv = "LLM_MASTER_KEY_FILE"
+
+	encryptedPrefix = "enc:v1:"
+)
+
+var ErrNoMasterKey = errors.New("未配置主密钥 " + masterKeyEnv + " | " + masterKeyFileEnv)
+
+func masterKey() ([]byte, error) {
+	value := os.Getenv(masterKeyEnv)
+	if value == "" {
+		file := os.Getenv(masterKeyFileEnv)
+		if file == "" {
+			return nil, nil
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		value = string(data)
+	}
+
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(value); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	sum := sha256.Sum256([]byte(value))
+	return sum[:], nil
+}
+
+// HasMasterKey 是否配置了主密钥
+func HasMasterKey() bool {
+	master, err := masterKey()
+	return err == nil && master != nil
+}
+
+// 主密钥文件和数据库放在一起等于没有加密
+func checkMasterKeyFile(dbPath string) error {
+	file := os.Getenv(masterKeyFileEnv)
+	if file == "" || os.Getenv(masterKeyEnv) != "" {
+		return nil
+	}
+
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	dir, err := filepath.Abs(filepath.Dir(dbPath))
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(dir, file); err == nil && !strings.HasPrefix(rel, "..") {
+		return errors.New(masterKeyFileEnv + " 不能放在数据目录下: " + file)
+	}
+	return nil
+}
+
+// IsEncrypted key 是否已加密保存
+func IsEncrypted(k *Key) bool {
+	return strings.HasPrefix(k.Content, encryptedPrefix)
+}
+
+// Decrypt 取出 key 的明文，只在发起请求时调用
+func Decrypt(k *Key) (string, error) {
+	if !IsEncrypted(k) {
+		return k.Content, nil
+	}
+
+	master, err := masterKey()
+	if err != nil {
+		return "", err
+	}
+	if master == nil {
+		return "", ErrNoMasterKey
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(k.Content, encryptedPrefix))
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := newGCM(master)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("key 密文已损坏: " + k.Name)
+	}
+
+	nonce, data := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, data, []byte(k.Name))
+	if err != nil {
+		return "", errors.New("key 解密失败，主密钥是否变更: " + k.Name)
+	}
+	return string(plain), nil
+}
+
+// 以 key 名称作为附加数据，密文不能挪到其它 key 下使用
+func encrypt(master []byte, k Key) (Key, error) {
+	if master == nil || IsEncrypted(&k) {
+		return k, nil
+	}
+
+	gcm, err := newGCM(master)
+	if err != nil {
+		return k, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return k, err
+	}
+
+	data := gcm.Seal(nonce, nonce, []byte(k.Content), []byte(k.Name))
+	k.Content = encryptedPrefix + base64.StdEncoding.EncodeToString(data)
+	return k, nil
+}
+
+func newGCM(master []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(master)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// 加密库中的明文 key，未配置主密钥时跳过
+func encryptKeys(tx *sql.Tx) error {
+	master, err := masterKey()
+	if err != nil || master == nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT Name, Content FROM \"Key\" WHERE Content NOT LIKE ?", encryptedPrefix+"%")
+	if err != nil {
+		return err
+	}
+
+	var keys []Key
+	for rows.Next() {
+		var k Key
+		if err = rows.Scan(&k.Name, &k.Content); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		keys = append(keys, k)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if k, err = encrypt(master, k); err != nil {
+			return err
+		}
+		if _, err = tx.Exec("UPDATE \"Key\" SET Content = ? WHERE Name = ?", k.Content, k.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
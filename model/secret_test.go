@@ -0,0 +1,94 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sqlite "github.com/FloatTech/sqlite"
+)
+
+func TestEncryptKeys(t *testing.T) {
+	t.Setenv(masterKeyEnv, "")
+	t.Setenv(masterKeyFileEnv, "")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.DB")
+
+	// 未配置主密钥时明文保存
+	sqlite.DriverName = "sqlite"
+	var d DB
+	must(t, d.Open(path))
+	must(t, d.SaveKey(Key{Name: "gpt", Content: "sk-gpt"}))
+	k, err := d.Key("gpt")
+	must(t, err)
+	if IsEncrypted(k) || k.Content != "sk-gpt" {
+		t.Fatalf("plain key = %+v", k)
+	}
+	must(t, d.Close())
+
+	// 配置主密钥后重新打开，已有的 key 被加密
+	t.Setenv(masterKeyEnv, "correct horse battery staple")
+	must(t, d.Open(path))
+	defer d.Close()
+	k, err = d.Key("gpt")
+	must(t, err)
+	if !IsEncrypted(k) || strings.Contains(k.Content, "sk-gpt") {
+		t.Fatalf("key not encrypted: %+v", k)
+	}
+	if secret, err := Decrypt(k); err != nil || secret != "sk-gpt" {
+		t.Fatalf("decrypt = %q, %v", secret, err)
+	}
+
+	must(t, d.SaveKey(Key{Name: "claude", Content: "sk-claude"}))
+	k, err = d.Key("claude")
+	must(t, err)
+	if secret, err := Decrypt(k); !IsEncrypted(k) || err != nil || secret != "sk-claude" {
+		t.Fatalf("decrypt = %q, %v", secret, err)
+	}
+
+	// 密文不能换到其它 key 名下
+	if _, err = Decrypt(&Key{Name: "gpt", Content: k.Content}); err == nil {
+		t.Fatal("decrypt with another name")
+	}
+
+	t.Setenv(masterKeyEnv, "wrong")
+	if _, err = Decrypt(k); err == nil {
+		t.Fatal("decrypt with wrong master key")
+	}
+
+	t.Setenv(masterKeyEnv, "")
+	if _, err = Decrypt(k); err != ErrNoMasterKey {
+		t.Fatalf("decrypt without master key: %v", err)
+	}
+}
+
+func TestMasterKeyFile(t *testing.T) {
+	t.Setenv(masterKeyEnv, "")
+	sqlite.DriverName = "sqlite"
+	dir := t.TempDir()
+
+	inside := filepath.Join(dir, "master.key")
+	must(t, os.WriteFile(inside, []byte("secret\n"), 0600))
+	t.Setenv(masterKeyFileEnv, inside)
+	var d DB
+	if err := d.Open(filepath.Join(dir, "data.DB")); err == nil {
+		_ = d.Close()
+		t.Fatal("master key file inside data folder")
+	}
+
+	outside := filepath.Join(t.TempDir(), "master.key")
+	must(t, os.WriteFile(outside, []byte("secret\n"), 0600))
+	t.Setenv(masterKeyFileEnv, outside)
+	must(t, d.Open(filepath.Join(dir, "data.DB")))
+	defer d.Close()
+
+	must(t, d.SaveKey(Key{Name: "gpt", Content: "sk-gpt"}))
+	k, err := d.Key("gpt")
+	must(t, err)
+	t.Setenv(masterKeyFileEnv, "")
+	t.Setenv(masterKeyEnv, "secret")
+	if secret, err := Decrypt(k); err != nil || secret != "sk-gpt" {
+		t.Fatalf("decrypt = %q, %v", secret, err)
+	}
+}
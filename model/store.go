@@ -0,0 +1,103 @@
+package model
+
+import (
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// Store 插件的数据存储，DB 为 sqlite 实现，Memory 为内存实现
+type Store interface {
+	KeyStore
+	ConfigStore
+	HistoryStore
+
+	// Vacuum 整理存储空间，不需要时直接返回
+	Vacuum() error
+	Close() error
+}
+
+// KeyStore 模型 key
+type KeyStore interface {
+	SaveKey(k Key) error
+	DelKey(name string) error
+	Keys() ([]*Key, error)
+	Key(name string) (*Key, error)
+	UseKey(name, model, health string) error
+}
+
+// ConfigStore 全局配置与零散配置项
+type ConfigStore interface {
+	Config() Config
+	UpdateConfig(c Config) error
+	Option(name, def string) string
+	OptionInt(name string, def int) int
+	OptionBool(name string, def bool) bool
+	SetOption(name, value string) error
+}
+
+// HistoryStore 对话记录，每个 uid:name 对话维护一棵分支树和当前末端
+type HistoryStore interface {
+	SaveHistory(h History) error
+	FindHistory(uid int64, name string, count int) ([]*History, error)
+	History(id int64) (*History, error)
+	Branches(uid int64, name string) ([]*History, error)
+	LastHistory(uid int64, name string) (*History, error)
+	DelHistory(h *History) error
+	Checkout(uid int64, name string, id int64) error
+	CleanHistories(uid int64, name string) error
+	CleanAllHistories(name string) error
+	PruneHistories(before int64, rows int) (int64, error)
+	Usages(limit int) ([]*Usage, error)
+}
+
+var (
+	_ Store = (*DB)(nil)
+	_ Store = (*Memory)(nil)
+)
+
+// 新增的 key 补上创建时间和尾号，Content 此时还是明文
+func newKey(k Key) Key {
+	if k.Created == 0 {
+		k.Created = time.Now().Unix()
+	}
+	if k.Hint == "" && !IsEncrypted(&k) {
+		k.Hint = maskSecret(k.Content)
+	}
+	return k
+}
+
+// 只保留末 4 位，过短的不显示
+func maskSecret(secret string) string {
+	if utf8.RuneCountInString(secret) <= 8 {
+		return "****"
+	}
+	r := []rune(secret)
+	return "****" + string(r[len(r)-4:])
+}
+
+func defaultConfig() Config {
+	return Config{
+		Timestamp: -1,
+		BaseUrl:   "https://api.openai.com",
+		Model:     "gpt-4-turbo",
+		Key:       "auto",
+		Freq:      25,
+	}
+}
+
+func parseInt(value string, def int) int {
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return def
+	}
+	return i
+}
+
+func parseBool(value string, def bool) bool {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return def
+	}
+	return b
+}
@@ -0,0 +1,212 @@
+package model
+
+import "testing"
+
+// sqlite 与内存实现的行为保持一致
+func TestStore(t *testing.T) {
+	stores := map[string]Store{
+		"sqlite": openDB(t),
+		"memory": NewMemory(),
+	}
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			testKeys(t, s)
+			testConfig(t, s)
+			testBranches(t, s)
+			testIds(t, s)
+			testFormat(t, s)
+			testPrune(t, s)
+		})
+	}
+}
+
+func testKeys(t *testing.T, s Store) {
+	must(t, s.SaveKey(Key{Name: "gpt", Content: "sk-0123456789", Provider: "api.openai.com"}))
+	k, err := s.Key("gpt")
+	must(t, err)
+	if k.Hint != "****6789" || k.Created == 0 || k.LastUsed != 0 {
+		t.Fatalf("key = %+v", k)
+	}
+
+	must(t, s.UseKey("gpt", "gpt-4o", HealthAuth))
+	k, err = s.Key("gpt")
+	must(t, err)
+	if k.Model != "gpt-4o" || k.Health != HealthAuth || k.LastUsed == 0 || k.Provider != "api.openai.com" {
+		t.Fatalf("used key = %+v", k)
+	}
+
+	// 重新设置只更新密钥和配置
+	used := *k
+	must(t, s.SaveKey(Key{Name: "gpt", Content: "sk-abcdefghijkl", Provider: "api.example.com"}))
+	k, err = s.Key("gpt")
+	must(t, err)
+	if k.Hint != "****ijkl" || k.Provider != "api.example.com" ||
+		k.Created != used.Created || k.LastUsed != used.LastUsed || k.Health != HealthAuth || k.Model != "gpt-4o" {
+		t.Fatalf("reset key = %+v, before %+v", k, used)
+	}
+	must(t, s.DelKey("gpt"))
+}
+
+func testConfig(t *testing.T, s Store) {
+	if c := s.Config(); c.Key != "auto" || c.Freq != 25 {
+		t.Fatalf("default config = %+v", c)
+	}
+
+	c := s.Config()
+	c.Key, c.Imitate = "gpt", true
+	must(t, s.UpdateConfig(c))
+	if c = s.Config(); c.Key != "gpt" || !c.Imitate {
+		t.Fatalf("config = %+v", c)
+	}
+
+	if s.OptionInt("queue_size", 5) != 5 || !s.OptionBool("queue", true) {
+		t.Fatal("option default")
+	}
+	must(t, s.SetOption("queue_size", "3"))
+	must(t, s.SetOption("queue", "x"))
+	if s.OptionInt("queue_size", 5) != 3 || !s.OptionBool("queue", true) {
+		t.Fatal("option value")
+	}
+}
+
+// /regen、/edit 会从中间分叉，/history 列出其它分支
+func testBranches(t *testing.T, s Store) {
+	save := func(content string) *History {
+		must(t, s.SaveHistory(History{Timestamp: 10, Uid: 1, Name: "gpt", UserContent: content, AssistantContent: content}))
+		h, err := s.LastHistory(1, "gpt")
+		must(t, err)
+		return h
+	}
+
+	a := save("a")
+	b := save("b")
+	must(t, s.Checkout(1, "gpt", a.Id))
+	c := save("c")
+
+	hs, err := s.FindHistory(1, "gpt", 10)
+	must(t, err)
+	if len(hs) != 2 || hs[0].Id != c.Id || hs[1].Id != a.Id {
+		t.Fatalf("path = %+v", hs)
+	}
+
+	branches, err := s.Branches(1, "gpt")
+	must(t, err)
+	if len(branches) != 1 || branches[0].Id != b.Id {
+		t.Fatalf("branches = %+v", branches)
+	}
+
+	// 有子记录的不删除，只移动末端
+	must(t, s.Checkout(1, "gpt", b.Id))
+	must(t, s.DelHistory(b))
+	if h, _ := s.LastHistory(1, "gpt"); h == nil || h.Id != a.Id {
+		t.Fatalf("head after del = %+v", h)
+	}
+	if _, err = s.History(b.Id); !IsNull(err) {
+		t.Fatalf("history(%d) = %v", b.Id, err)
+	}
+	must(t, s.DelHistory(a))
+	if _, err = s.History(a.Id); err != nil {
+		t.Fatalf("history(%d) = %v", a.Id, err)
+	}
+
+	must(t, s.CleanHistories(1, "gpt"))
+	if _, err = s.FindHistory(1, "gpt", 10); !IsNull(err) {
+		t.Fatalf("after clean = %v", err)
+	}
+	if _, err = s.Branches(1, "gpt"); !IsNull(err) {
+		t.Fatalf("branches after clean = %v", err)
+	}
+}
+
+// 删除过的 id 不会再分配，失效的末端不会指到其它对话
+func testIds(t *testing.T, s Store) {
+	must(t, s.SaveHistory(History{Timestamp: 1, Uid: 4, Name: "gpt", UserContent: "a", AssistantContent: "a"}))
+	a, err := s.LastHistory(4, "gpt")
+	must(t, err)
+	must(t, s.DelHistory(a))
+
+	must(t, s.SaveHistory(History{Timestamp: 2, Uid: 5, Name: "gpt", UserContent: "b", AssistantContent: "b"}))
+	b, err := s.LastHistory(5, "gpt")
+	must(t, err)
+	if b.Id <= a.Id {
+		t.Fatalf("id reused: %d after %d", b.Id, a.Id)
+	}
+
+	must(t, s.Checkout(4, "gpt", b.Id))
+	if hs, err := s.FindHistory(4, "gpt", 10); !IsNull(err) {
+		t.Fatalf("cross conversation = %+v, %v", hs, err)
+	}
+	must(t, s.CleanHistories(4, "gpt"))
+	must(t, s.CleanHistories(5, "gpt"))
+}
+
+// 提问格式随记录保存，不从内容推断
+func testFormat(t *testing.T, s Store) {
+	must(t, s.SaveHistory(History{Timestamp: 1, Uid: 9, Name: "gpt", UserContent: `[{"uid":1}]`, AssistantContent: "a"}))
+	must(t, s.SaveHistory(History{Timestamp: 2, Uid: 9, Name: "gpt", UserContent: `[{"uid":2}]`, AssistantContent: "b", Format: FormatStructured}))
+	hs, err := s.FindHistory(9, "gpt", 10)
+	must(t, err)
+	if len(hs) != 2 || hs[0].Format != FormatStructured || hs[1].Format != "" {
+		t.Fatalf("format = %+v", hs)
+	}
+
+	// 按字节统计，中文一个字 3 字节
+	must(t, s.SaveHistory(History{Timestamp: 3, Uid: 9, Name: "gpt", UserContent: "你好", AssistantContent: "好"}))
+	usages, err := s.Usages(1)
+	must(t, err)
+	if len(usages) != 1 || usages[0].Uid != 9 || usages[0].Rows != 3 || usages[0].Bytes != 2*11+2+9 {
+		t.Fatalf("usages = %+v", usages)
+	}
+	must(t, s.CleanHistories(9, "gpt"))
+}
+
+func testPrune(t *testing.T, s Store) {
+	for i := int64(1); i <= 5; i++ {
+		must(t, s.SaveHistory(History{Timestamp: i, Uid: 2, Name: "gpt", UserContent: "u", AssistantContent: "a"}))
+		must(t, s.SaveHistory(History{Timestamp: i, Uid: 3, Name: "gpt", UserContent: "uu", AssistantContent: "aa"}))
+	}
+
+	usages, err := s.Usages(1)
+	must(t, err)
+	if len(usages) != 1 || usages[0].Uid != 3 || usages[0].Rows != 5 || usages[0].Bytes != 20 {
+		t.Fatalf("usages = %+v", usages)
+	}
+
+	n, err := s.PruneHistories(2, 0)
+	must(t, err)
+	if n != 2 {
+		t.Fatalf("prune before = %d", n)
+	}
+
+	n, err = s.PruneHistories(0, 2)
+	must(t, err)
+	if n != 4 {
+		t.Fatalf("prune rows = %d", n)
+	}
+
+	hs, err := s.FindHistory(2, "gpt", 10)
+	must(t, err)
+	if len(hs) != 2 || hs[0].Timestamp != 5 || hs[1].Timestamp != 4 {
+		t.Fatalf("after prune = %+v", hs)
+	}
+
+	// 末端指向的记录被清理后，新记录不会出现在原来的对话里
+	must(t, s.SaveHistory(History{Timestamp: 1, Uid: 6, Name: "gpt", UserContent: "group6", AssistantContent: "a"}))
+	must(t, s.SaveHistory(History{Timestamp: 1, Uid: 7, Name: "gpt", UserContent: "group7", AssistantContent: "a"}))
+	_, err = s.PruneHistories(2, 0)
+	must(t, err)
+	must(t, s.SaveHistory(History{Timestamp: 9, Uid: 8, Name: "gpt", UserContent: "group8 new", AssistantContent: "a"}))
+	for _, uid := range []int64{6, 7} {
+		if hs, err = s.FindHistory(uid, "gpt", 10); !IsNull(err) {
+			t.Fatalf("history(%d) after prune = %+v, %v", uid, hs, err)
+		}
+	}
+	if hs, err = s.FindHistory(2, "gpt", 10); err != nil || hs[len(hs)-1].Parent != 0 {
+		t.Fatalf("parent after prune = %+v, %v", hs, err)
+	}
+
+	must(t, s.CleanAllHistories("gpt"))
+	if _, err = s.Usages(10); !IsNull(err) {
+		t.Fatalf("usages after clean = %v", err)
+	}
+}
@@ -0,0 +1,15 @@
+-- 引入 schema_version 之前的 data.DB，由 sqlite.Create 生成
+CREATE TABLE 'Key' ( Name TEXT NOT NULL PRIMARY KEY, Content TEXT NOT NULL );
+CREATE TABLE 'History' ( Timestamp BIGINT NOT NULL PRIMARY KEY, Uid BIGINT NOT NULL , Name TEXT NOT NULL , UserContent TEXT NOT NULL , AssistantContent TEXT NOT NULL );
+CREATE TABLE 'config' ( Timestamp BIGINT NOT NULL PRIMARY KEY, Proxies TEXT NOT NULL , BaseUrl TEXT NOT NULL , Key TEXT NOT NULL , Model TEXT NOT NULL , Imitate BOOLEAN NOT NULL , Freq INTEGER NOT NULL );
+
+INSERT INTO Key VALUES ('gpt', 'sk-gpt');
+INSERT INTO Key VALUES ('claude', 'sk-claude');
+
+INSERT INTO config VALUES (1700000000, '', 'https://api.openai.com', 'gpt', 'gpt-4-turbo', 1, 30);
+
+INSERT INTO History VALUES (1700000001, 100, 'gpt', 'u1', 'a1');
+INSERT INTO History VALUES (1700000002, 200, 'gpt', 'u2', 'a2');
+INSERT INTO History VALUES (1700000003, 100, 'gpt', 'u3', 'a3');
+INSERT INTO History VALUES (1700000004, 100, 'claude', 'u4', 'a4');
+INSERT INTO History VALUES (1700000005, 100, 'gpt', 'u5', 'a5');
@@ -0,0 +1,169 @@
+// Package paragraph 按段落切分 markdown 文本，不拆开 ``` 代码块
+package paragraph
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// 按行扫描，返回代码块之外的空行位置（空行所在行的起始偏移）
+func breaks(text string) []int {
+	var (
+		positions []int
+		inFence   bool
+		offset    int
+	)
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			inFence = !inFence
+		case trimmed == "" && !inFence && i > 0 && i < len(lines)-1:
+			// 最后一行可能还没写完，不作为分隔
+			positions = append(positions, offset)
+		}
+		offset += len(line) + 1
+	}
+	return positions
+}
+
+// Cut 在最后一个代码块之外的空行处切开，之前的部分至少 min 个字。用于流式输出时分段发送
+func Cut(buf string, min int) (head, rest string, ok bool) {
+	positions := breaks(buf)
+	for i := len(positions) - 1; i >= 0; i-- {
+		head = strings.TrimSpace(buf[:positions[i]])
+		if head != "" && utf8.RuneCountInString(head) >= min {
+			return head, strings.TrimLeft(buf[positions[i]:], "\n"), true
+		}
+	}
+	return "", buf, false
+}
+
+// Split 按段落切成每段不超过 max 个字，超长的段落先按行再按字数切开，
+// 代码块从中间切开时补上结尾并在下一段重新打开
+func Split(text string, max int) []string {
+	var (
+		blocks []string
+		last   int
+	)
+	for _, pos := range breaks(text) {
+		if block := strings.TrimSpace(text[last:pos]); block != "" {
+			blocks = append(blocks, block)
+		}
+		last = pos
+	}
+	if block := strings.TrimSpace(text[last:]); block != "" {
+		blocks = append(blocks, block)
+	}
+
+	var (
+		chunks []string
+		chunk  string
+	)
+	for _, block := range blocks {
+		// 同一段切出来的部分之间不加空行
+		if pieces := splitBlock(block, max); len(pieces) > 1 {
+			if chunk != "" {
+				chunks = append(chunks, chunk)
+			}
+			chunks = append(chunks, pieces[:len(pieces)-1]...)
+			chunk = pieces[len(pieces)-1]
+			continue
+		}
+
+		if chunk != "" && utf8.RuneCountInString(chunk)+2+utf8.RuneCountInString(block) > max {
+			chunks = append(chunks, chunk)
+			chunk = ""
+		}
+		if chunk != "" {
+			chunk += "\n\n"
+		}
+		chunk += block
+	}
+	if chunk != "" {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// 超过 max 的段落按行切开，单行超长时按字数切
+func splitBlock(block string, max int) []string {
+	if utf8.RuneCountInString(block) <= max {
+		return []string{block}
+	}
+
+	var (
+		pieces []string
+		piece  string
+		fence  string // 所在代码块的开始行
+	)
+	for _, line := range strings.Split(block, "\n") {
+		trimmed := strings.TrimSpace(line)
+		closing := fence != "" && strings.HasPrefix(trimmed, "```")
+
+		reserve := 0
+		if fence != "" {
+			reserve = len("\n```")
+		}
+		for _, part := range splitRunes(line, max-reserve) {
+			// 代码块的结尾行直接接上，避免切出空的代码块
+			if !closing && piece != "" && piece != fence &&
+				utf8.RuneCountInString(piece)+1+utf8.RuneCountInString(part)+reserve > max {
+				if fence != "" {
+					piece += "\n```"
+				}
+				pieces = append(pieces, piece)
+				piece = fence
+			}
+			if piece != "" {
+				piece += "\n"
+			}
+			piece += part
+		}
+
+		switch {
+		case closing:
+			fence = ""
+		case strings.HasPrefix(trimmed, "```"):
+			fence = trimmed
+		}
+	}
+	if piece != "" && piece != fence {
+		pieces = append(pieces, piece)
+	}
+	return pieces
+}
+
+func splitRunes(line string, max int) []string {
+	runes := []rune(line)
+	if max < 1 || len(runes) <= max {
+		return []string{line}
+	}
+
+	var parts []string
+	for len(runes) > max {
+		parts = append(parts, string(runes[:max]))
+		runes = runes[max:]
+	}
+	return append(parts, string(runes))
+}
+
+// HasCodeBlock 是否包含 ``` 代码块
+func HasCodeBlock(text string) bool {
+	return strings.Count(text, "```") >= 2
+}
+
+// HasTable 是否包含 markdown 表格
+func HasTable(text string) bool {
+	lines := strings.Split(text, "\n")
+	for i := 1; i < len(lines); i++ {
+		header, sep := strings.TrimSpace(lines[i-1]), strings.TrimSpace(lines[i])
+		if strings.HasPrefix(header, "|") && strings.HasPrefix(sep, "|") && strings.Contains(sep, "---") &&
+			strings.Trim(sep, "|-: ") == "" {
+			return true
+		}
+	}
+	return false
+}
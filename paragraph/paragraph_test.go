@@ -0,0 +1,70 @@
+package paragraph
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCut(t *testing.T) {
+	for _, c := range []struct {
+		buf, head, rest string
+		min             int
+		ok              bool
+	}{
+		{"第一段\n\n第二段", "第一段", "第二段", 1, true},
+		{"第一段\n\n第二段\n\n第三", "第一段\n\n第二段", "第三", 1, true},
+		{"第一段\n\n第二段", "", "第一段\n\n第二段", 10, false},
+		// 还没写完的空行不切
+		{"第一段\n", "", "第一段\n", 1, false},
+		{"第一段\n\n", "第一段", "", 1, true},
+		// 代码块里的空行不切
+		{"代码:\n```go\na := 1\n\nb := 2\n```\n\n说明", "代码:\n```go\na := 1\n\nb := 2\n```", "说明", 1, true},
+		{"代码:\n```go\na := 1\n\nb := 2", "", "代码:\n```go\na := 1\n\nb := 2", 1, false},
+	} {
+		head, rest, ok := Cut(c.buf, c.min)
+		if head != c.head || rest != c.rest || ok != c.ok {
+			t.Errorf("Cut(%q, %d) = %q, %q, %v", c.buf, c.min, head, rest, ok)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	text := "aaaa\n\nbbbb\n\ncccc\n\n```\nx\n\ny\n```\n\ndd"
+	chunks := Split(text, 12)
+	want := []string{"aaaa\n\nbbbb", "cccc", "```\nx\n\ny\n```", "dd"}
+	if !slices.Equal(chunks, want) {
+		t.Fatalf("Split = %q", chunks)
+	}
+
+	if chunks = Split("", 10); len(chunks) != 0 {
+		t.Fatalf("Split empty = %q", chunks)
+	}
+}
+
+// 超长的段落先按行切，单行超长再按字数切
+func TestSplitLong(t *testing.T) {
+	for _, c := range []struct {
+		text string
+		max  int
+		want []string
+	}{
+		{"一段很长很长很长的文字", 3, []string{"一段很", "长很长", "很长的", "文字"}},
+		{"第一行\n第二行很长很长", 5, []string{"第一行", "第二行很长", "很长"}},
+		{"短\n\n第一行\n第二行\n\n尾", 7, []string{"短", "第一行\n第二行", "尾"}},
+		{"```go\na := 1\nb := 2\nc := 3\n```", 20,
+			[]string{"```go\na := 1\n```", "```go\nb := 2\n```", "```go\nc := 3\n```"}},
+	} {
+		if chunks := Split(c.text, c.max); !slices.Equal(chunks, c.want) {
+			t.Errorf("Split(%q, %d) = %q", c.text, c.max, chunks)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	if !HasCodeBlock("a\n```go\nb\n```") || HasCodeBlock("a ``` b") {
+		t.Error("HasCodeBlock")
+	}
+	if !HasTable("| a | b |\n| --- | :-: |\n| 1 | 2 |") || HasTable("a | b\n---") {
+		t.Error("HasTable")
+	}
+}
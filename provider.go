@@ -0,0 +1,238 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bincooo/emit.io"
+	"github.com/bincooo/zerobot-llm/model"
+)
+
+const probeTimeout = 15 * time.Second
+
+// 用模型列表接口校验 key，不消耗额度
+func probeKey(c model.Config, secret string) (string, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	response, err := emit.ClientBuilder().
+		Context(timeout).
+		Proxies(c.Proxies).
+		GET(c.BaseUrl+"/v1/models").
+		Header("Authorization", "Bearer "+secret).
+		DoC(emit.Status(http.StatusOK))
+	if err != nil {
+		return keyHealth(err), err
+	}
+	_ = response.Body.Close()
+	return model.HealthOk, nil
+}
+
+// 按请求错误区分 key 的状态
+func keyHealth(err error) string {
+	var emitErr emit.Error
+	if !errors.As(err, &emitErr) || emitErr.Code <= 0 {
+		return model.HealthError
+	}
+
+	switch emitErr.Code {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return model.HealthAuth
+	case http.StatusTooManyRequests:
+		return model.HealthLimited
+	default:
+		return model.HealthError
+	}
+}
+
+// 模型列表缓存
+type modelList struct {
+	models []string
+	at     time.Time
+}
+
+const (
+	modelsTTL = 10 * time.Minute
+	maxModels = 100 // /models 最多显示的条数
+)
+
+var (
+	modelLists  = make(map[string]modelList)
+	modelListMu sync.Mutex
+)
+
+// 查询 key 可用的模型，结果缓存 modelsTTL
+func listModels(name string) ([]string, error) {
+	c := Db.Config()
+	cacheKey := name + "@" + c.BaseUrl
+	modelListMu.Lock()
+	list, ok := modelLists[cacheKey]
+	modelListMu.Unlock()
+	if ok && time.Since(list.at) < modelsTTL {
+		return list.models, nil
+	}
+
+	k, err := Db.Key(name)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := model.Decrypt(k)
+	if err != nil {
+		return nil, err
+	}
+
+	models, err := fetchModels(c, secret)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(models)
+
+	modelListMu.Lock()
+	modelLists[cacheKey] = modelList{models, time.Now()}
+	modelListMu.Unlock()
+	return models, nil
+}
+
+// 按 baseUrl 区分 OpenAI 兼容接口、Ollama、Gemini
+func fetchModels(c model.Config, secret string) ([]string, error) {
+	host := provider(c.BaseUrl)
+	switch {
+	case strings.Contains(host, "generativelanguage.googleapis.com"):
+		return fetchGeminiModels(c, secret)
+	case strings.HasSuffix(host, ":11434") || strings.Contains(host, "ollama"):
+		return fetchOllamaModels(c)
+	}
+
+	models, err := fetchOpenaiModels(c, secret)
+	var emitErr emit.Error
+	if errors.As(err, &emitErr) && emitErr.Code == http.StatusNotFound {
+		return fetchOllamaModels(c)
+	}
+	return models, err
+}
+
+func fetchOpenaiModels(c model.Config, secret string) ([]string, error) {
+	var obj struct {
+		Data []struct {
+			Id string `json:"id"`
+		} `json:"data"`
+	}
+	if err := getJson(c, c.BaseUrl+"/v1/models", secret, &obj); err != nil {
+		return nil, err
+	}
+
+	models := make([]string, 0, len(obj.Data))
+	for _, m := range obj.Data {
+		models = append(models, m.Id)
+	}
+	return models, nil
+}
+
+func fetchOllamaModels(c model.Config) ([]string, error) {
+	var obj struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := getJson(c, c.BaseUrl+"/api/tags", "", &obj); err != nil {
+		return nil, err
+	}
+
+	models := make([]string, 0, len(obj.Models))
+	for _, m := range obj.Models {
+		models = append(models, m.Name)
+	}
+	return models, nil
+}
+
+func fetchGeminiModels(c model.Config, secret string) ([]string, error) {
+	var obj struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := getJson(c, c.BaseUrl+"/v1beta/models?pageSize=1000&key="+url.QueryEscape(secret), "", &obj); err != nil {
+		return nil, err
+	}
+
+	models := make([]string, 0, len(obj.Models))
+	for _, m := range obj.Models {
+		models = append(models, strings.TrimPrefix(m.Name, "models/"))
+	}
+	return models, nil
+}
+
+func getJson(c model.Config, u, secret string, obj interface{}) error {
+	timeout, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	builder := emit.ClientBuilder().
+		Context(timeout).
+		Proxies(c.Proxies).
+		GET(u)
+	if secret != "" {
+		builder = builder.Header("Authorization", "Bearer "+secret)
+	}
+
+	response, err := builder.DoC(emit.Status(http.StatusOK))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	return json.NewDecoder(response.Body).Decode(obj)
+}
+
+// 按编辑距离给出最接近的几个模型，包含输入的优先
+func suggestModels(models []string, target string, n int) []string {
+	type candidate struct {
+		model    string
+		distance int
+	}
+
+	target = strings.ToLower(target)
+	candidates := make([]candidate, 0, len(models))
+	for _, m := range models {
+		lower := strings.ToLower(m)
+		distance := levenshtein(lower, target)
+		if strings.Contains(lower, target) || strings.Contains(target, lower) {
+			distance -= len(target)
+		}
+		candidates = append(candidates, candidate{m, distance})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].distance < candidates[j].distance })
+	var suggestions []string
+	for i := 0; i < len(candidates) && i < n; i++ {
+		suggestions = append(suggestions, candidates[i].model)
+	}
+	return suggestions
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
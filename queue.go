@@ -0,0 +1,223 @@
+package llm
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bincooo/zerobot-llm/ring"
+	"github.com/sirupsen/logrus"
+	"github.com/wdvxdr1123/ZeroBot/message"
+
+	zero "github.com/wdvxdr1123/ZeroBot"
+)
+
+// 同一聊天室同一key的请求串行执行，生成期间到达的消息合并到下一轮
+type conversation struct {
+	sync.Mutex
+	running bool
+	pending []pendingMessage
+
+	// 上一轮回复的结束原因，用于 /continue
+	finishReason string
+}
+
+type pendingMessage struct {
+	ctx     *zero.Ctx
+	content prompt
+}
+
+var (
+	conversations  = make(map[string]*conversation)
+	conversationMu sync.Mutex
+
+	queueSize = 5
+)
+
+func loadConversation(uid int64, name string) *conversation {
+	conversationMu.Lock()
+	defer conversationMu.Unlock()
+	k := conversationKey(uid, name)
+	conv, ok := conversations[k]
+	if !ok {
+		conv = &conversation{}
+		conversations[k] = conv
+	}
+	return conv
+}
+
+func conversationKey(uid int64, name string) string {
+	return strconv.FormatInt(uid, 10) + ":" + name
+}
+
+// 提交对话请求。
+// 关闭队列时沿用旧逻辑：限流直接丢弃并记录日志
+func submit(ctx *zero.Ctx, uid int64, name string, content prompt, count int) {
+	if !Db.OptionBool("queue", true) {
+		if !acquire(uid, false) {
+			return
+		}
+		chat(ctx, uid, name, content, count)
+		return
+	}
+
+	conv := loadConversation(uid, name)
+	conv.Lock()
+	if conv.running {
+		size := Db.OptionInt("queue_size", queueSize)
+		if len(conv.pending) >= size {
+			conv.Unlock()
+			logrus.Warnf("当前请求队列已满: %d", uid)
+			rateLimited.Inc("queue")
+			ctx.SendChain(message.Reply(ctx.Event.MessageID), message.Text("排队的消息太多了，请稍后再试。"))
+			return
+		}
+
+		conv.pending = append(conv.pending, pendingMessage{ctx, content})
+		position := len(conv.pending)
+		conv.Unlock()
+		if position > 1 {
+			ctx.SendChain(message.Reply(ctx.Event.MessageID), message.Text("排队中，当前第 ", position, " 条，将在本轮回复后合并处理。"))
+		}
+		return
+	}
+	conv.running = true
+	conv.Unlock()
+	conv.serve(ctx, uid, name, content, count)
+}
+
+// 模仿模式的随机发言，和艾特 | 指令对话共用对话的运行状态。
+// 对话正在进行时跳过，群友消息留在缓存里；发言期间排队的消息在结束后照常处理
+func imitate(ctx *zero.Ctx, uid int64, name string, chat *ring.Buffer[cacheMessage]) {
+	conv := loadConversation(uid, name)
+	conv.Lock()
+	if conv.running {
+		conv.Unlock()
+		logrus.Infof("对话进行中，跳过自由发言: %d", uid)
+		return
+	}
+	conv.running = true
+	conv.Unlock()
+
+	finished := false
+	defer func() {
+		if !finished {
+			conv.reset()
+		}
+	}()
+
+	histories, err := Db.FindHistory(uid, name, historyL)
+	if err != nil && !IsSqlNull(err) {
+		logrus.Error(err)
+		return
+	}
+
+	if messages := chat.Drain(time.Now()); len(messages) > 0 {
+		var wait func()
+		withStream(name, priorityImitate, func() {
+			wait = completions(ctx, uid, name, joinMessages(name, messages), histories)
+		})
+		if wait != nil {
+			wait()
+		}
+	}
+
+	msg, ok := conv.next()
+	finished = true
+	if ok {
+		conv.serve(msg.ctx, uid, name, msg.content, historyL)
+	}
+}
+
+// 依次处理本轮以及之后排队的消息，全部完成后释放对话
+func (conv *conversation) serve(ctx *zero.Ctx, uid int64, name string, content prompt, count int) {
+	// 正常结束时在检查队列的同一把锁内复位；chat 中途 panic 时由这里复位，避免一直处于运行中
+	finished := false
+	defer func() {
+		if !finished {
+			conv.reset()
+		}
+	}()
+
+	for {
+		acquire(uid, true)
+		chat(ctx, uid, name, content, count)
+
+		msg, ok := conv.next()
+		if !ok {
+			finished = true
+			return
+		}
+		ctx, content = msg.ctx, msg.content
+	}
+}
+
+// 排队的消息合并为下一轮，没有时释放对话
+func (conv *conversation) next() (pendingMessage, bool) {
+	conv.Lock()
+	defer conv.Unlock()
+	if len(conv.pending) == 0 {
+		conv.running = false
+		return pendingMessage{}, false
+	}
+
+	contents := make([]prompt, len(conv.pending))
+	for i, msg := range conv.pending {
+		contents[i] = msg.content
+	}
+	msg := pendingMessage{conv.pending[len(conv.pending)-1].ctx, mergePrompts(contents)}
+	conv.pending = nil
+	return msg, true
+}
+
+func (conv *conversation) reset() {
+	conv.Lock()
+	conv.running = false
+	conv.Unlock()
+}
+
+// 限流检查，wait 为 true 时阻塞等待
+func acquire(uid int64, wait bool) bool {
+	limiter := limitManager.Load(uid)
+	for {
+		if now := time.Now(); now.Before(limit) {
+			if !wait {
+				logrus.Warnf("当前请求限流: %d", uid)
+				rateLimited.Inc("cooldown")
+				return false
+			}
+			time.Sleep(limit.Sub(now))
+			continue
+		}
+
+		if limiter.Acquire() {
+			return true
+		}
+
+		if !wait {
+			logrus.Warnf("当前请求限流: %d", uid)
+			rateLimited.Inc("limiter")
+			return false
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+func chat(ctx *zero.Ctx, uid int64, name string, content prompt, count int) {
+	histories, err := Db.FindHistory(uid, name, count)
+	if err != nil && !IsSqlNull(err) {
+		ctx.Send(message.Text("ERROR: ", err))
+		return
+	}
+	var wait func()
+	ok := withStream(name, priorityMention, func() {
+		wait = completions(ctx, uid, name, content, histories)
+	})
+	if !ok {
+		ctx.SendChain(message.Reply(ctx.Event.MessageID), message.Text("当前请求过多，等待超时，请稍后再试。"))
+	}
+	// 模仿模式的片段发完才开始下一轮
+	if wait != nil {
+		wait()
+	}
+}
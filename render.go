@@ -0,0 +1,222 @@
+package llm
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/FloatTech/floatbox/file"
+	"github.com/FloatTech/gg"
+	"github.com/FloatTech/rendercard"
+	"github.com/FloatTech/zbputils/control"
+	"github.com/FloatTech/zbputils/img/text"
+	"github.com/bincooo/zerobot-llm/render"
+	"golang.org/x/image/font"
+)
+
+const (
+	renderWidth    = 1080.0
+	renderPadding  = 48.0
+	renderTextSize = 30.0
+	renderCodeSize = 26.0
+	renderHeadSize = 38.0
+	renderSpacing  = 1.5
+
+	renderMaxHeight = 8000.0 // 超过时不渲染，改用合并转发 | 文本
+)
+
+// 排版后的一行
+type renderLine struct {
+	text  string
+	face  font.Face
+	x     float64
+	y     float64 // 基线位置
+	color [3]int
+}
+
+// 代码块 | 表格的背景
+type renderBlock struct {
+	y, h float64
+}
+
+type renderFaces struct {
+	text, bold, code font.Face
+}
+
+func shouldRender(tex string) bool {
+	return render.Should(Db.Option("render", render.Auto), tex)
+}
+
+func loadFace(name string, size float64) (font.Face, error) {
+	data, err := file.GetLazyData(name, control.Md5File, true)
+	if err != nil {
+		return nil, err
+	}
+	return gg.ParseFontFace(data, size)
+}
+
+func loadFaces() (faces renderFaces, err error) {
+	if faces.text, err = loadFace(text.FontFile, renderTextSize); err != nil {
+		return
+	}
+	if faces.bold, err = loadFace(text.BoldFontFile, renderHeadSize); err != nil {
+		return
+	}
+	faces.code, err = loadFace(text.ConsolasFontFile, renderCodeSize)
+	return
+}
+
+// 把 markdown 回复渲染成 png
+func renderMarkdown(tex string) ([]byte, error) {
+	faces, err := loadFaces()
+	if err != nil {
+		return nil, err
+	}
+
+	dc := gg.NewContext(1, 1)
+	lines, blocks, height := layoutMarkdown(dc, faces, tex)
+	if height+renderPadding > renderMaxHeight {
+		return nil, errors.New("回复过长，图片高度超过上限")
+	}
+
+	canvas := gg.NewContext(int(renderWidth), int(height+renderPadding))
+	canvas.SetRGB255(250, 250, 250)
+	canvas.Clear()
+
+	for _, b := range blocks {
+		canvas.SetRGB255(238, 240, 243)
+		canvas.DrawRoundedRectangle(renderPadding-16, b.y, renderWidth-2*renderPadding+32, b.h, 12)
+		canvas.Fill()
+	}
+
+	for _, line := range lines {
+		canvas.SetFontFace(line.face)
+		canvas.SetRGB255(line.color[0], line.color[1], line.color[2])
+		canvas.DrawString(line.text, line.x, line.y)
+	}
+
+	var buf bytes.Buffer
+	img := rendercard.Fillet(canvas.Image(), 16)
+	if err = gg.NewContextForImage(img).EncodePNG(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 逐行排版，返回各行位置、代码块背景和总高度
+func layoutMarkdown(dc *gg.Context, faces renderFaces, tex string) (lines []renderLine, blocks []renderBlock, y float64) {
+	y = renderPadding
+	maxWidth := renderWidth - 2*renderPadding
+
+	measure := func(s string) float64 {
+		w, _ := dc.MeasureString(s)
+		return w
+	}
+
+	add := func(s string, face font.Face, x float64, color [3]int) {
+		dc.SetFontFace(face)
+		for _, wrapped := range render.Wrap(measure, s, maxWidth-(x-renderPadding)) {
+			height := float64(face.Metrics().Height) / 64
+			y += height * renderSpacing
+			lines = append(lines, renderLine{wrapped, face, x, y - height*(renderSpacing-1)/2 - float64(face.Metrics().Descent)/64, color})
+		}
+	}
+
+	// 代码按词着色，每段单独一个 renderLine
+	addCode := func(s, lang string, face font.Face, x float64) {
+		dc.SetFontFace(face)
+		for _, wrapped := range render.Wrap(measure, s, maxWidth-(x-renderPadding)) {
+			height := float64(face.Metrics().Height) / 64
+			y += height * renderSpacing
+			baseline := y - height*(renderSpacing-1)/2 - float64(face.Metrics().Descent)/64
+			offset := x
+			for _, tok := range render.Highlight(lang, wrapped) {
+				lines = append(lines, renderLine{tok.Text, face, offset, baseline, tok.Color})
+				offset += measure(tok.Text)
+			}
+		}
+	}
+
+	var (
+		inCode     bool
+		lang       string // 代码块声明的语言
+		blockStart float64
+		table      [][]string
+	)
+
+	flushTable := func() {
+		if len(table) == 0 {
+			return
+		}
+		start := y
+		dc.SetFontFace(faces.text)
+		for _, row := range render.Table(measure, table, maxWidth) {
+			add(row, faces.text, renderPadding, [3]int{40, 40, 40})
+		}
+		blocks = append(blocks, renderBlock{start + 4, y - start + 8})
+		y += 16
+		table = nil
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(tex), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			if inCode {
+				blocks = append(blocks, renderBlock{blockStart, y - blockStart + 16})
+				y += 24
+			} else {
+				flushTable()
+				y += 8
+				blockStart = y
+				lang = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(trimmed, "```")))
+			}
+			inCode = !inCode
+			continue
+		}
+
+		if inCode {
+			// consolas 没有中文字形
+			face := faces.code
+			if !isASCII(line) {
+				face = faces.text
+			}
+			addCode(strings.ReplaceAll(line, "\t", "    "), lang, face, renderPadding+8)
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "|") {
+			table = append(table, render.SplitRow(trimmed))
+			continue
+		}
+		flushTable()
+
+		switch {
+		case trimmed == "":
+			y += renderTextSize / 2
+		case strings.HasPrefix(trimmed, "#"):
+			add(strings.TrimSpace(strings.TrimLeft(trimmed, "#")), faces.bold, renderPadding, [3]int{20, 20, 20})
+		case strings.HasPrefix(trimmed, "- "), strings.HasPrefix(trimmed, "* "):
+			indent := float64(len(line)-len(strings.TrimLeft(line, " \t"))) * 8
+			add("• "+trimmed[2:], faces.text, renderPadding+16+indent, [3]int{40, 40, 40})
+		default:
+			add(line, faces.text, renderPadding, [3]int{40, 40, 40})
+		}
+	}
+
+	if inCode {
+		blocks = append(blocks, renderBlock{blockStart, y - blockStart + 16})
+		y += 24
+	}
+	flushTable()
+	return
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
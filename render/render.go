@@ -0,0 +1,199 @@
+// Package render 回复渲染成图片时的文本排版: 是否渲染、换行、表格对齐和代码高亮
+package render
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bincooo/zerobot-llm/paragraph"
+)
+
+// 渲染方式: auto 包含代码块 | 表格时渲染，always 总是渲染，off 关闭
+const (
+	Auto   = "auto"
+	Always = "always"
+	Off    = "off"
+)
+
+// Should 按渲染方式判断回复是否渲染成图片
+func Should(mode, tex string) bool {
+	switch mode {
+	case Always:
+		return true
+	case Auto:
+		return paragraph.HasCodeBlock(tex) || paragraph.HasTable(tex)
+	default:
+		return false
+	}
+}
+
+// Measure 当前字体下文本的宽度
+type Measure func(s string) float64
+
+// Wrap 按字符宽度换行，中文没有空格也能断开
+func Wrap(measure Measure, s string, width float64) []string {
+	if s == "" {
+		return []string{""}
+	}
+
+	var (
+		wrapped []string
+		line    strings.Builder
+		w       float64
+	)
+	for _, r := range s {
+		rw := measure(string(r))
+		if w+rw > width && line.Len() > 0 {
+			wrapped = append(wrapped, line.String())
+			line.Reset()
+			w = 0
+		}
+		line.WriteRune(r)
+		w += rw
+	}
+	return append(wrapped, line.String())
+}
+
+// SplitRow 拆分 markdown 表格的一行
+func SplitRow(row string) []string {
+	cells := strings.Split(strings.Trim(row, "|"), "|")
+	for i := range cells {
+		cells[i] = strings.TrimSpace(cells[i])
+	}
+	return cells
+}
+
+// Table 表格按列宽用空格对齐，分隔行换成横线
+func Table(measure Measure, table [][]string, maxWidth float64) []string {
+	space := measure(" ")
+
+	var widths []float64
+	for _, row := range table {
+		for i, cell := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], measure(cell))
+		}
+	}
+
+	total := 0.0
+	for _, w := range widths {
+		total += w + 3*space
+	}
+
+	rows := make([]string, 0, len(table))
+	for _, row := range table {
+		if strings.Trim(strings.Join(row, ""), "-: ") == "" {
+			rows = append(rows, strings.Repeat("─", min(40, int(total/space/2)+1)))
+			continue
+		}
+
+		// 太宽时不对齐，直接用 | 分隔
+		if total > maxWidth {
+			rows = append(rows, strings.Join(row, " | "))
+			continue
+		}
+
+		var sb strings.Builder
+		for i, cell := range row {
+			sb.WriteString(cell)
+			if i < len(row)-1 {
+				sb.WriteString(strings.Repeat(" ", int((widths[i]-measure(cell))/space)+3))
+			}
+		}
+		rows = append(rows, sb.String())
+	}
+	return rows
+}
+
+// Color RGB
+type Color [3]int
+
+var (
+	ColorCode    = Color{36, 41, 46}
+	ColorKeyword = Color{207, 34, 46}
+	ColorString  = Color{10, 48, 105}
+	ColorComment = Color{110, 119, 129}
+	ColorNumber  = Color{5, 80, 174}
+
+	keywords = map[string]bool{}
+
+	// 以 # 开始注释的语言，其它语言按 // 注释处理
+	hashComments = map[string]bool{}
+)
+
+func init() {
+	for _, k := range strings.Fields(`break case catch class const continue def default defer do elif else enum
+		export extends false final for fn from func function go if impl import in interface let match mut
+		new nil none null package pub public private return select self static struct switch this throw true
+		try type var void while yield async await lambda and or not None True False`) {
+		keywords[k] = true
+	}
+	for _, lang := range strings.Fields(`sh bash shell zsh fish console python py python3 ruby rb perl pl
+		r yaml yml toml dockerfile makefile make cmake nginx conf ini properties powershell ps1 elixir ex nim`) {
+		hashComments[lang] = true
+	}
+}
+
+// Token 着色后的一段代码
+type Token struct {
+	Text  string
+	Color Color
+}
+
+// Highlight 简单的通用语法高亮: 关键字、字符串、数字和行尾注释。lang 为代码块声明的语言
+func Highlight(lang, line string) (tokens []Token) {
+	hash := hashComments[strings.ToLower(strings.TrimSpace(lang))]
+	push := func(text string, color Color) {
+		if n := len(tokens); n > 0 && tokens[n-1].Color == color {
+			tokens[n-1].Text += text
+			return
+		}
+		tokens = append(tokens, Token{text, color})
+	}
+
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case hash && c == '#', !hash && strings.HasPrefix(line[i:], "//"):
+			push(line[i:], ColorComment)
+			return
+		case c == '"' || c == '\'' || c == '`':
+			j := i + 1
+			for j < len(line) && line[j] != c {
+				if line[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			j = min(j+1, len(line))
+			push(line[i:j], ColorString)
+			i = j
+		case isWordByte(c):
+			j := i
+			for j < len(line) && isWordByte(line[j]) {
+				j++
+			}
+			word := line[i:j]
+			switch {
+			case keywords[word]:
+				push(word, ColorKeyword)
+			case c >= '0' && c <= '9':
+				push(word, ColorNumber)
+			default:
+				push(word, ColorCode)
+			}
+			i = j
+		default:
+			_, size := utf8.DecodeRuneInString(line[i:])
+			push(line[i:i+size], ColorCode)
+			i += size
+		}
+	}
+	return
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
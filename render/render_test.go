@@ -0,0 +1,94 @@
+package render
+
+import (
+	"reflect"
+	"testing"
+	"unicode/utf8"
+)
+
+// 每个字符宽 10
+func measure(s string) float64 {
+	return float64(utf8.RuneCountInString(s)) * 10
+}
+
+func TestShould(t *testing.T) {
+	code := "看代码:\n```go\nfmt.Println(1)\n```"
+	table := "| a | b |\n| --- | --- |\n| 1 | 2 |"
+	for _, c := range []struct {
+		mode, tex string
+		want      bool
+	}{
+		{Auto, code, true},
+		{Auto, table, true},
+		{Auto, "普通回复", false},
+		{Auto, "只有一个 ``` 标记", false},
+		{Always, "普通回复", true},
+		{Off, code, false},
+		{"", table, false},
+	} {
+		if got := Should(c.mode, c.tex); got != c.want {
+			t.Errorf("Should(%q, %q) = %v, want %v", c.mode, c.tex, got, c.want)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	for _, c := range []struct {
+		lang, line string
+		want       []Token
+	}{
+		{"c", "#include <stdio.h>", []Token{{"#include <stdio.h>", ColorCode}}},
+		{"css", "color: #fff;", []Token{{"color: #fff;", ColorCode}}},
+		{"markdown", "# 标题", []Token{{"# 标题", ColorCode}}},
+		{"bash", "# comment", []Token{{"# comment", ColorComment}}},
+		{"Python", "x = 1 # one", []Token{{"x = ", ColorCode}, {"1", ColorNumber}, {" ", ColorCode}, {"# one", ColorComment}}},
+		{"python", "a // b", []Token{{"a // b", ColorCode}}},
+		{"go", "return x // done", []Token{{"return", ColorKeyword}, {" x ", ColorCode}, {"// done", ColorComment}}},
+		{"", `s := "a#b // c"`, []Token{{"s := ", ColorCode}, {`"a#b // c"`, ColorString}}},
+		{"sh", `echo 'it\'s' # x`, []Token{{"echo ", ColorCode}, {`'it\'s'`, ColorString}, {" ", ColorCode}, {"# x", ColorComment}}},
+		{"js", "", nil},
+	} {
+		if got := Highlight(c.lang, c.line); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Highlight(%q, %q) = %v, want %v", c.lang, c.line, got, c.want)
+		}
+	}
+}
+
+func TestWrap(t *testing.T) {
+	for _, c := range []struct {
+		s     string
+		width float64
+		want  []string
+	}{
+		{"", 30, []string{""}},
+		{"abc", 30, []string{"abc"}},
+		{"abcdefg", 30, []string{"abc", "def", "g"}},
+		{"中文字符串", 25, []string{"中文", "字符", "串"}},
+		// 宽度不足一个字符时每行至少放一个
+		{"ab", 5, []string{"a", "b"}},
+	} {
+		if got := Wrap(measure, c.s, c.width); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Wrap(%q, %v) = %q, want %q", c.s, c.width, got, c.want)
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	table := [][]string{
+		SplitRow("| a | bb |"),
+		SplitRow("|:---|---:|"),
+		SplitRow("| ccc | d |"),
+	}
+	for _, c := range []struct {
+		maxWidth float64
+		want     []string
+	}{
+		{200, []string{"a      bb", "────────", "ccc    d"}},
+		// 超过最大宽度时不对齐
+		{100, []string{"a | bb", "────────", "ccc | d"}},
+	} {
+		if got := Table(measure, table, c.maxWidth); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Table(%v) = %q, want %q", c.maxWidth, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Package ring 带过期时间的定长缓冲，写满后覆盖最旧的元素
+package ring
+
+import (
+	"sync"
+	"time"
+)
+
+type entry[T any] struct {
+	at    time.Time
+	value T
+}
+
+// Buffer 并发安全的环形缓冲，maxAge 之前写入的元素读取时忽略，maxAge 为 0 时不过期
+type Buffer[T any] struct {
+	mu     sync.Mutex
+	items  []entry[T]
+	head   int // 最旧元素的位置
+	n      int
+	maxAge time.Duration
+}
+
+func New[T any](size int, maxAge time.Duration) *Buffer[T] {
+	if size < 1 {
+		size = 1
+	}
+	return &Buffer[T]{items: make([]entry[T], size), maxAge: maxAge}
+}
+
+// Push 写入一个元素，已满时覆盖最旧的
+func (b *Buffer[T]) Push(at time.Time, value T) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.push(entry[T]{at, value})
+}
+
+func (b *Buffer[T]) push(e entry[T]) {
+	size := len(b.items)
+	if b.n < size {
+		b.items[(b.head+b.n)%size] = e
+		b.n++
+		return
+	}
+	b.items[b.head] = e
+	b.head = (b.head + 1) % size
+}
+
+// Recent 未过期的元素，从旧到新
+func (b *Buffer[T]) Recent(now time.Time) []T {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.recent(now)
+}
+
+// Drain 取出未过期的元素并清空
+func (b *Buffer[T]) Drain(now time.Time) []T {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	values := b.recent(now)
+	b.clear()
+	return values
+}
+
+func (b *Buffer[T]) Clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clear()
+}
+
+// Count since 之后写入的元素个数
+func (b *Buffer[T]) Count(since time.Time) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	count := 0
+	b.each(func(e entry[T]) {
+		if e.at.After(since) {
+			count++
+		}
+	})
+	return count
+}
+
+func (b *Buffer[T]) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.n
+}
+
+// Resize 修改容量和过期时间，保留最新的元素
+func (b *Buffer[T]) Resize(size int, maxAge time.Duration) {
+	if size < 1 {
+		size = 1
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var entries []entry[T]
+	b.each(func(e entry[T]) { entries = append(entries, e) })
+	if len(entries) > size {
+		entries = entries[len(entries)-size:]
+	}
+
+	b.items = make([]entry[T], size)
+	b.head, b.n = 0, 0
+	b.maxAge = maxAge
+	for _, e := range entries {
+		b.push(e)
+	}
+}
+
+func (b *Buffer[T]) recent(now time.Time) []T {
+	values := make([]T, 0, b.n)
+	b.each(func(e entry[T]) {
+		if b.maxAge <= 0 || now.Sub(e.at) < b.maxAge {
+			values = append(values, e.value)
+		}
+	})
+	return values
+}
+
+func (b *Buffer[T]) each(f func(e entry[T])) {
+	for i := 0; i < b.n; i++ {
+		f(b.items[(b.head+i)%len(b.items)])
+	}
+}
+
+func (b *Buffer[T]) clear() {
+	clear(b.items)
+	b.head, b.n = 0, 0
+}
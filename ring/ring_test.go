@@ -0,0 +1,110 @@
+package ring
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBuffer(t *testing.T) {
+	now := time.Now()
+	b := New[int](3, 10*time.Minute)
+	for i := 1; i <= 5; i++ {
+		b.Push(now, i)
+	}
+	if values := b.Recent(now); !slices.Equal(values, []int{3, 4, 5}) {
+		t.Fatalf("recent = %v", values)
+	}
+
+	// 过期的不返回
+	b.Push(now.Add(-11*time.Minute), 6)
+	if values := b.Recent(now); !slices.Equal(values, []int{4, 5}) {
+		t.Fatalf("recent after expired push = %v", values)
+	}
+	if count := b.Count(now.Add(-time.Minute)); count != 2 {
+		t.Fatalf("count = %d", count)
+	}
+
+	if values := b.Drain(now); !slices.Equal(values, []int{4, 5}) || b.Len() != 0 {
+		t.Fatalf("drain = %v, len = %d", values, b.Len())
+	}
+
+	b.Push(now, 7)
+	if values := b.Recent(now); !slices.Equal(values, []int{7}) {
+		t.Fatalf("recent after drain = %v", values)
+	}
+}
+
+func TestResize(t *testing.T) {
+	now := time.Now()
+	b := New[int](4, 0)
+	for i := 1; i <= 6; i++ {
+		b.Push(now.Add(-time.Duration(6-i)*time.Minute), i)
+	}
+
+	b.Resize(2, 0)
+	if values := b.Recent(now); !slices.Equal(values, []int{5, 6}) {
+		t.Fatalf("shrink = %v", values)
+	}
+
+	b.Resize(3, 30*time.Second)
+	b.Push(now, 7)
+	if values := b.Recent(now); !slices.Equal(values, []int{6, 7}) {
+		t.Fatalf("grow = %v", values)
+	}
+	if b.Len() != 3 {
+		t.Fatalf("len = %d", b.Len())
+	}
+}
+
+// go test -race 下并发读写
+func TestConcurrent(t *testing.T) {
+	const (
+		writers = 8
+		writes  = 1000
+		size    = 16
+	)
+
+	b := New[int](size, time.Hour)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				b.Push(time.Now(), i)
+			}
+		}()
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes/10; i++ {
+				switch i % 4 {
+				case 0:
+					if n := len(b.Recent(time.Now())); n > size {
+						t.Errorf("recent len = %d", n)
+					}
+				case 1:
+					b.Count(time.Now().Add(-time.Minute))
+				case 2:
+					if w == 0 {
+						b.Resize(size, time.Hour)
+					}
+				default:
+					b.Drain(time.Now())
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if n := b.Len(); n > size {
+		t.Fatalf("len = %d", n)
+	}
+	for i := 0; i < size*2; i++ {
+		b.Push(time.Now(), i)
+	}
+	if n := b.Len(); n != size {
+		t.Fatalf("len after fill = %d", n)
+	}
+}
@@ -0,0 +1,86 @@
+package llm
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bincooo/zerobot-llm/schedule"
+)
+
+// 群单独设置 schedule.<gid>，其次全局 schedule
+func loadSchedules(gid int64) []schedule.Range {
+	value := Db.Option("schedule", "")
+	if gid != 0 {
+		value = Db.Option("schedule."+strconv.FormatInt(gid, 10), value)
+	}
+
+	schedules, _ := schedule.Parse(value)
+	return schedules
+}
+
+// 当前时段的缩放比例
+func scheduleScale(gid int64, now time.Time) int {
+	return schedule.Scale(loadSchedules(gid), now)
+}
+
+// 机器人在同一聊天室连续自由发言的次数，沉默超过 streakWindow 后重新计数
+type streak struct {
+	count int
+	last  time.Time // 上一次发言时间
+	until time.Time // 冷却结束时间
+}
+
+const streakWindow = 5 * time.Minute
+
+var (
+	streaks  = make(map[int64]*streak)
+	streakMu sync.Mutex
+)
+
+// 冷却设置: cooldown 连续 N 次后冷却，cooldown_minutes 冷却分钟数，可按群覆盖
+func cooldownOption(gid int64) (count int, minutes int) {
+	count = Db.OptionInt("cooldown", 0)
+	minutes = Db.OptionInt("cooldown_minutes", 10)
+	if gid != 0 {
+		id := strconv.FormatInt(gid, 10)
+		count = Db.OptionInt("cooldown."+id, count)
+		minutes = Db.OptionInt("cooldown_minutes."+id, minutes)
+	}
+	return
+}
+
+// 记录一次实际发出的自由发言回复，一条回复的多个片段只算一次，连续达到上限后进入冷却
+func markStreak(uid, gid int64) {
+	count, minutes := cooldownOption(gid)
+	if count <= 0 {
+		return
+	}
+
+	streakMu.Lock()
+	defer streakMu.Unlock()
+	s, ok := streaks[uid]
+	if !ok {
+		s = &streak{}
+		streaks[uid] = s
+	}
+
+	now := time.Now()
+	if now.Sub(s.last) > streakWindow {
+		s.count = 0
+	}
+	s.count++
+	s.last = now
+
+	if s.count >= count {
+		s.count = 0
+		s.until = now.Add(time.Duration(minutes) * time.Minute)
+	}
+}
+
+func coolingDown(uid int64) bool {
+	streakMu.Lock()
+	defer streakMu.Unlock()
+	s, ok := streaks[uid]
+	return ok && time.Now().Before(s.until)
+}
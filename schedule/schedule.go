@@ -0,0 +1,82 @@
+// Package schedule 自由发言时段，"23:00-07:00=0,12:00-13:30=50"
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Range start~end 内触发概率按 Scale% 缩放，0 为关闭。End 小于 Start 时跨天
+type Range struct {
+	Start int // 一天中的分钟
+	End   int
+	Scale int
+}
+
+var rangeRe = regexp.MustCompile(`^(\d{1,2}):(\d{2})-(\d{1,2}):(\d{2})=(\d+)$`)
+
+// Parse 解析逗号分隔的时段，空字符串表示全天
+func Parse(value string) ([]Range, error) {
+	var ranges []Range
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		matched := rangeRe.FindStringSubmatch(item)
+		if matched == nil {
+			return nil, errors.New("格式错误: " + item)
+		}
+
+		var n [5]int
+		for i := range n {
+			n[i], _ = strconv.Atoi(matched[i+1])
+		}
+		start, end := n[0]*60+n[1], n[2]*60+n[3]
+		if n[1] > 59 || n[3] > 59 || start > 24*60 || end > 24*60 {
+			return nil, errors.New("时间错误: " + item)
+		}
+		ranges = append(ranges, Range{start, end, n[4]})
+	}
+	return ranges, nil
+}
+
+// Contains t 是否在时段内，包含开始不包含结束
+func (r Range) Contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if r.Start <= r.End {
+		return m >= r.Start && m < r.End
+	}
+	return m >= r.Start || m < r.End
+}
+
+func (r Range) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d=%d", r.Start/60, r.Start%60, r.End/60, r.End%60, r.Scale)
+}
+
+// Scale 当前时段的缩放比例，命中多个时取第一个，都不命中为 100
+func Scale(ranges []Range, now time.Time) int {
+	for _, r := range ranges {
+		if r.Contains(now) {
+			return r.Scale
+		}
+	}
+	return 100
+}
+
+// Format 与 Parse 的格式一致
+func Format(ranges []Range) string {
+	if len(ranges) == 0 {
+		return "全天"
+	}
+
+	items := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		items = append(items, r.String())
+	}
+	return strings.Join(items, ",")
+}
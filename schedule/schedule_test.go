@@ -0,0 +1,75 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for _, c := range []struct {
+		value, want string
+		ok          bool
+	}{
+		{"", "全天", true},
+		{"23:00-07:00=0", "23:00-07:00=0", true},
+		{" 23:00-7:00=0 , 12:00-13:30=50 ", "23:00-07:00=0,12:00-13:30=50", true},
+		{"00:00-24:00=10", "00:00-24:00=10", true},
+		{"23:00-07:00", "", false},
+		{"23:60-07:00=0", "", false},
+		{"25:00-07:00=0", "", false},
+		{"23:00-07:00=-1", "", false},
+	} {
+		ranges, err := Parse(c.value)
+		if (err == nil) != c.ok {
+			t.Errorf("Parse(%q) err = %v", c.value, err)
+			continue
+		}
+		if c.ok && Format(ranges) != c.want {
+			t.Errorf("Parse(%q) = %q, want %q", c.value, Format(ranges), c.want)
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 1, hour, minute, 0, 0, time.Local)
+	}
+	night := Range{Start: 23 * 60, End: 7 * 60}
+	noon := Range{Start: 12 * 60, End: 13*60 + 30}
+
+	for _, c := range []struct {
+		r    Range
+		t    time.Time
+		want bool
+	}{
+		// 跨天
+		{night, at(23, 0), true},
+		{night, at(0, 0), true},
+		{night, at(6, 59), true},
+		{night, at(7, 0), false},
+		{night, at(22, 59), false},
+		{night, at(12, 0), false},
+		// 不跨天，包含开始不包含结束
+		{noon, at(12, 0), true},
+		{noon, at(13, 29), true},
+		{noon, at(13, 30), false},
+		{noon, at(11, 59), false},
+		{Range{Start: 0, End: 24 * 60}, at(23, 59), true},
+	} {
+		if got := c.r.Contains(c.t); got != c.want {
+			t.Errorf("%s contains %s = %v", c.r, c.t.Format("15:04"), got)
+		}
+	}
+}
+
+func TestScale(t *testing.T) {
+	ranges, err := Parse("23:00-07:00=0,06:00-08:00=50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for hour, want := range map[int]int{2: 0, 6: 0, 7: 50, 12: 100} {
+		if got := Scale(ranges, time.Date(2024, 1, 1, hour, 0, 0, 0, time.Local)); got != want {
+			t.Errorf("Scale(%d:00) = %d, want %d", hour, got, want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package llm
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bincooo/zerobot-llm/semaphore"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// 模仿模式随机发言
+	priorityImitate = iota
+	// 艾特 | 指令 对话
+	priorityMention
+)
+
+var (
+	// 全局上游连接数
+	streams     = semaphore.New(int64(concurrency))
+	streamsOnce sync.Once
+
+	keyStreams  = make(map[string]*semaphore.Semaphore)
+	keyStreamMu sync.Mutex
+
+	concurrency   = 8
+	streamTimeout = 2 * time.Minute
+)
+
+func loadKeyStreams(name string) *semaphore.Semaphore {
+	keyStreamMu.Lock()
+	defer keyStreamMu.Unlock()
+	s, ok := keyStreams[name]
+	if !ok {
+		s = semaphore.New(int64(Db.OptionInt("concurrency."+name, 0)))
+		keyStreams[name] = s
+	}
+	return s
+}
+
+func loadStreams() *semaphore.Semaphore {
+	streamsOnce.Do(func() {
+		streams.Resize(int64(Db.OptionInt("concurrency", concurrency)))
+	})
+	return streams
+}
+
+// 获取key以及全局的上游连接名额，返回释放函数
+func acquireStream(ctx context.Context, name string, priority int) (func(), error) {
+	ks := loadKeyStreams(name)
+	if err := ks.Acquire(ctx, 1, priority); err != nil {
+		return nil, err
+	}
+
+	if err := loadStreams().Acquire(ctx, 1, priority); err != nil {
+		ks.Release(1)
+		return nil, err
+	}
+
+	return func() {
+		ks.Release(1)
+		streams.Release(1)
+	}, nil
+}
+
+// 等待上游连接名额后执行 f，超时放弃
+func withStream(name string, priority int, f func()) bool {
+	timeout, cancel := context.WithTimeout(context.Background(), streamTimeout)
+	defer cancel()
+
+	release, err := acquireStream(timeout, name, priority)
+	if err != nil {
+		logrus.Warnf("等待上游连接超时: %s", name)
+		rateLimited.Inc("concurrency")
+		return false
+	}
+	defer release()
+	f()
+	return true
+}
@@ -0,0 +1,118 @@
+// Package semaphore 带优先级的加权信号量
+package semaphore
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Semaphore 优先级高的等待者先获取，同级先到先得。size <= 0 时不限制
+type Semaphore struct {
+	mu      sync.Mutex
+	size    int64
+	cur     int64
+	waiters []*waiter
+}
+
+type waiter struct {
+	n        int64
+	priority int
+	since    time.Time
+	ready    chan struct{}
+}
+
+func New(size int64) *Semaphore {
+	return &Semaphore{size: size}
+}
+
+// Acquire 获取 n 个名额，ctx 结束时放弃等待
+func (s *Semaphore) Acquire(ctx context.Context, n int64, priority int) error {
+	s.mu.Lock()
+	if len(s.waiters) == 0 && s.fits(n) {
+		s.cur += n
+		s.mu.Unlock()
+		return nil
+	}
+
+	w := &waiter{n: n, priority: priority, since: time.Now(), ready: make(chan struct{})}
+	// 优先级高的排在前面，同级先到先得
+	i := sort.Search(len(s.waiters), func(i int) bool {
+		return s.waiters[i].priority < priority
+	})
+	s.waiters = append(s.waiters, nil)
+	copy(s.waiters[i+1:], s.waiters[i:])
+	s.waiters[i] = w
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		select {
+		case <-w.ready:
+			// 取消的同时已获取到，归还
+			s.cur -= n
+			s.notify()
+		default:
+			s.remove(w)
+			s.notify()
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (s *Semaphore) Release(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cur -= n
+	s.notify()
+}
+
+// Resize 修改上限，已获取的名额不受影响
+func (s *Semaphore) Resize(size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.size = size
+	s.notify()
+}
+
+// Status 当前使用量、上限、等待数以及最久的等待时长
+func (s *Semaphore) Status() (cur, size int64, waiting int, wait time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, w := range s.waiters {
+		if d := time.Since(w.since); d > wait {
+			wait = d
+		}
+	}
+	return s.cur, s.size, len(s.waiters), wait
+}
+
+func (s *Semaphore) fits(n int64) bool {
+	return s.size <= 0 || s.cur+n <= s.size
+}
+
+func (s *Semaphore) notify() {
+	for len(s.waiters) > 0 {
+		w := s.waiters[0]
+		if !s.fits(w.n) {
+			break
+		}
+		s.cur += w.n
+		s.waiters = s.waiters[1:]
+		close(w.ready)
+	}
+}
+
+func (s *Semaphore) remove(w *waiter) {
+	for i, it := range s.waiters {
+		if it == w {
+			s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package semaphore
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	low = iota
+	high
+)
+
+// 等到 n 个等待者入队
+func waitFor(t *testing.T, s *Semaphore, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, _, waiting, _ := s.Status(); waiting == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("waiters != %d", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func acquireAsync(s *Semaphore, ctx context.Context, priority int) chan error {
+	ch := make(chan error, 1)
+	go func() { ch <- s.Acquire(ctx, 1, priority) }()
+	return ch
+}
+
+// 艾特对话先于模仿发言，同级先到先得
+func TestPriority(t *testing.T) {
+	s := New(1)
+	ctx := context.Background()
+	must(t, s.Acquire(ctx, 1, low))
+
+	var (
+		mu    sync.Mutex
+		order []string
+		wg    sync.WaitGroup
+	)
+	for i, c := range []struct {
+		name     string
+		priority int
+	}{{"low1", low}, {"low2", low}, {"high1", high}, {"high2", high}} {
+		wg.Add(1)
+		go func(name string, priority int) {
+			defer wg.Done()
+			if err := s.Acquire(ctx, 1, priority); err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			s.Release(1)
+		}(c.name, c.priority)
+		waitFor(t, s, i+1)
+	}
+
+	s.Release(1)
+	wg.Wait()
+	want := []string{"high1", "high2", "low1", "low2"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("order = %v, want %v", order, want)
+		}
+	}
+	checkIdle(t, s)
+}
+
+func TestTimeout(t *testing.T) {
+	s := New(1)
+	must(t, s.Acquire(context.Background(), 1, high))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.Acquire(ctx, 1, high); err == nil {
+		t.Fatal("acquire should time out")
+	}
+	if _, _, waiting, _ := s.Status(); waiting != 0 {
+		t.Fatalf("waiting = %d after timeout", waiting)
+	}
+
+	s.Release(1)
+	checkIdle(t, s)
+}
+
+// 获取到名额的同时超时，名额归还，不会泄漏
+func TestCancelGranted(t *testing.T) {
+	s := New(1)
+	for i := 0; i < 500; i++ {
+		must(t, s.Acquire(context.Background(), 1, high))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		ch := acquireAsync(s, ctx, low)
+		waitFor(t, s, 1)
+
+		go cancel()
+		s.Release(1)
+		if err := <-ch; err == nil {
+			s.Release(1)
+		}
+		checkIdle(t, s)
+	}
+}
+
+// 持有名额时调整上限
+func TestResize(t *testing.T) {
+	s := New(2)
+	ctx := context.Background()
+	must(t, s.Acquire(ctx, 1, high))
+	must(t, s.Acquire(ctx, 1, high))
+
+	// 调小后已持有的不受影响，释放到新上限以下才放行
+	s.Resize(1)
+	ch := acquireAsync(s, ctx, high)
+	waitFor(t, s, 1)
+	s.Release(1)
+	select {
+	case <-ch:
+		t.Fatal("granted above the new size")
+	case <-time.After(20 * time.Millisecond):
+	}
+	s.Release(1)
+	must(t, <-ch)
+
+	// 调大后立即放行等待者
+	ch = acquireAsync(s, ctx, low)
+	waitFor(t, s, 1)
+	s.Resize(2)
+	must(t, <-ch)
+	if cur, size, _, _ := s.Status(); cur != 2 || size != 2 {
+		t.Fatalf("status = %d/%d", cur, size)
+	}
+
+	// 0 为不限制
+	s.Resize(0)
+	must(t, s.Acquire(ctx, 5, low))
+	s.Release(5)
+	s.Release(2)
+	checkIdle(t, s)
+}
+
+func checkIdle(t *testing.T, s *Semaphore) {
+	t.Helper()
+	if cur, _, waiting, _ := s.Status(); cur != 0 || waiting != 0 {
+		t.Fatalf("cur = %d, waiting = %d", cur, waiting)
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,142 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bincooo/zerobot-llm/model"
+)
+
+// 结构化格式下群友的每条消息单独作为一条 user 消息，并带上 name 字段
+const (
+	formatText       = model.FormatText
+	formatStructured = model.FormatStructured
+)
+
+// 一轮提问。格式随提问一起传递并保存到对话记录，
+// 只有 joinMessages 生成的内容按结构化解析，用户输入的文本永远按文本处理
+type prompt struct {
+	content string
+	format  string
+}
+
+func textPrompt(content string) prompt {
+	return prompt{content, formatText}
+}
+
+func historyPrompt(h *model.History) prompt {
+	return prompt{h.UserContent, h.Format}
+}
+
+func (p prompt) structured() bool {
+	return p.format == formatStructured
+}
+
+// 展示、导出用的纯文本
+func (p prompt) plain() string {
+	speakers, ok := p.speakers()
+	if !ok {
+		return p.content
+	}
+
+	strMessages := make([]string, 0, len(speakers))
+	for _, s := range speakers {
+		strMessages = append(strMessages, cacheMessage{time.Unix(s.Time, 0), s.Uid, s.Nickname, s.Content}.String())
+	}
+	return strings.Join(strMessages, "\n\n")
+}
+
+func (p prompt) speakers() ([]speaker, bool) {
+	if !p.structured() {
+		return nil, false
+	}
+
+	var speakers []speaker
+	if err := json.Unmarshal([]byte(p.content), &speakers); err != nil || len(speakers) == 0 {
+		return nil, false
+	}
+	return speakers, true
+}
+
+// 排队期间的多条提问合并为一轮，都是结构化时合并发言人列表，否则按文本拼接
+func mergePrompts(prompts []prompt) prompt {
+	var all []speaker
+	for _, p := range prompts {
+		speakers, ok := p.speakers()
+		if !ok {
+			all = nil
+			break
+		}
+		all = append(all, speakers...)
+	}
+	if all != nil {
+		data, _ := json.Marshal(all)
+		return prompt{string(data), formatStructured}
+	}
+
+	contents := make([]string, len(prompts))
+	for i, p := range prompts {
+		contents[i] = p.plain()
+	}
+	return textPrompt(strings.Join(contents, "\n\n"))
+}
+
+// 一条群友消息，结构化格式下以 json 数组保存到对话记录
+type speaker struct {
+	Uid      int64  `json:"uid"`
+	Nickname string `json:"nickname"`
+	Content  string `json:"content"`
+	Time     int64  `json:"time"`
+}
+
+// 每个 key (人设) 单独设置 format.<key>
+func structured(name string) bool {
+	return Db.Option("format."+name, formatText) == formatStructured
+}
+
+func speakerOf(msg cacheMessage) speaker {
+	return speaker{msg.uid, msg.nickname, msg.content, msg.Unix()}
+}
+
+// 按 key 的格式拼接群友消息
+func joinMessages(name string, messages []cacheMessage) prompt {
+	if structured(name) {
+		speakers := make([]speaker, 0, len(messages))
+		for _, msg := range messages {
+			speakers = append(speakers, speakerOf(msg))
+		}
+		data, _ := json.Marshal(speakers)
+		return prompt{string(data), formatStructured}
+	}
+
+	strMessages := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		strMessages = append(strMessages, msg.String())
+	}
+	return textPrompt(strings.Join(strMessages, "\n\n"))
+}
+
+// 提问转换成请求消息
+func userMessages(p prompt) []map[string]string {
+	speakers, ok := p.speakers()
+	if !ok {
+		return []map[string]string{{
+			"role":    "user",
+			"content": p.content,
+		}}
+	}
+
+	// name 只允许字母数字，昵称放到内容的发言人标签里
+	messages := make([]map[string]string, 0, len(speakers))
+	for _, s := range speakers {
+		messages = append(messages, map[string]string{
+			"role":    "user",
+			"name":    "u" + strconv.FormatInt(s.Uid, 10),
+			"content": fmt.Sprintf("[%s]: %s", s.Nickname, s.Content),
+		})
+	}
+	return messages
+}
@@ -0,0 +1,203 @@
+package llm
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/wdvxdr1123/ZeroBot/message"
+
+	zero "github.com/wdvxdr1123/ZeroBot"
+)
+
+// 模仿模式分段发送时模拟打字速度
+const (
+	typingCps      = 6  // 每秒字数，0 关闭
+	typingJitter   = 30 // 随机浮动 %
+	typingMerge    = 0  // 短于该字数的片段与下一段合并，0 关闭
+	typingMaxDelay = 8 * time.Second
+)
+
+// 等待在单独的协程里进行，不占用请求的超时和并发名额。
+// 调用方在 wait 返回前不释放对话，被 /stop 中断时丢弃还没发送的片段
+type typist struct {
+	ctx     *zero.Ctx
+	r       *rand.Rand
+	cps     int
+	jitter  int
+	merge   int
+	last    time.Time
+	pending string
+
+	mu      sync.Mutex
+	queue   []fragment
+	closed  bool
+	stopped bool
+	sent    bool // 是否实际发出过片段
+	wake    chan struct{}
+	stop    <-chan struct{}
+	done    chan struct{}
+}
+
+type fragment struct {
+	tex  string
+	toAt bool
+}
+
+func newTypist(ctx *zero.Ctx, stop <-chan struct{}) *typist {
+	t := &typist{
+		ctx:    ctx,
+		r:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		cps:    Db.OptionInt("typing_cps", typingCps),
+		jitter: Db.OptionInt("typing_jitter", typingJitter),
+		merge:  Db.OptionInt("typing_merge", typingMerge),
+		last:   time.Now(),
+		wake:   make(chan struct{}, 1),
+		stop:   stop,
+		done:   make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// 发送一段回复，过短的先攒着
+func (t *typist) send(tex string, toAt bool) {
+	if t.pending != "" {
+		tex = t.pending + " " + tex
+		t.pending = ""
+	}
+
+	if utf8.RuneCountInString(tex) < t.merge {
+		t.pending = tex
+		return
+	}
+	t.push(tex, toAt)
+}
+
+func (t *typist) flush(toAt bool) {
+	if t.pending != "" {
+		tex := t.pending
+		t.pending = ""
+		t.push(tex, toAt)
+	}
+}
+
+// 放入发送队列，不等待。中断后的片段直接丢弃
+func (t *typist) push(tex string, toAt bool) {
+	t.mu.Lock()
+	if !t.stopped {
+		t.queue = append(t.queue, fragment{tex, toAt})
+	}
+	t.mu.Unlock()
+	t.notify()
+}
+
+// 不再有新的片段，队列发完后协程退出
+func (t *typist) close() {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+	t.notify()
+}
+
+func (t *typist) notify() {
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// 等待队列发完 | 被中断
+func (t *typist) wait() {
+	<-t.done
+}
+
+func (t *typist) run() {
+	defer close(t.done)
+	// 整条回复只计一次连续发言
+	defer func() {
+		if t.sent {
+			uid := t.ctx.Event.UserID
+			if t.ctx.Event.GroupID > 0 {
+				uid = t.ctx.Event.GroupID
+			}
+			markStreak(uid, t.ctx.Event.GroupID)
+		}
+	}()
+	for {
+		t.mu.Lock()
+		if len(t.queue) == 0 {
+			closed := t.closed
+			t.mu.Unlock()
+			if closed {
+				return
+			}
+			select {
+			case <-t.wake:
+			case <-t.stop:
+				t.interrupt()
+				return
+			}
+			continue
+		}
+		f := t.queue[0]
+		t.queue = t.queue[1:]
+		t.mu.Unlock()
+
+		if !t.deliver(f.tex, f.toAt) {
+			t.interrupt()
+			return
+		}
+	}
+}
+
+// 丢弃剩下的片段
+func (t *typist) interrupt() {
+	t.mu.Lock()
+	t.stopped = true
+	t.queue = nil
+	t.mu.Unlock()
+	t.ctx.SendChain(message.Text("[已中断]"))
+}
+
+// 按字数等待，已经花在生成上的时间算在内。等待中被中断时不发送，返回 false
+func (t *typist) deliver(tex string, toAt bool) bool {
+	timer := time.NewTimer(max(t.delay(tex)-time.Since(t.last), 0))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-t.stop:
+		return false
+	}
+	select {
+	case <-t.stop:
+		return false
+	default:
+	}
+
+	var id message.MessageID
+	if toAt {
+		id = t.ctx.SendChain(message.Reply(t.ctx.Event.MessageID), message.Text(tex))
+	} else {
+		id = t.ctx.SendChain(message.Text(tex))
+	}
+	t.last = time.Now()
+
+	if id.ID() != 0 {
+		t.sent = true
+	}
+	return true
+}
+
+func (t *typist) delay(tex string) time.Duration {
+	if t.cps <= 0 {
+		return 0
+	}
+
+	seconds := float64(utf8.RuneCountInString(tex)) / float64(t.cps)
+	if t.jitter > 0 {
+		seconds *= 1 + float64(t.r.Intn(2*t.jitter+1)-t.jitter)/100
+	}
+	return min(time.Duration(seconds*float64(time.Second)), typingMaxDelay)
+}